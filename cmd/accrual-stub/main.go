@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nbvehbq/go-loyalty-service/internal/logger"
+	"github.com/nbvehbq/go-loyalty-service/internal/model"
+	"go.uber.org/zap"
+)
+
+const defaultRetryAfter = 60
+
+type stub struct {
+	scenario *Scenario
+	state    *state
+}
+
+func main() {
+	var address, scenarioPath, logLevel string
+	flag.StringVar(&address, "a", "localhost:8080", "stub address")
+	flag.StringVar(&scenarioPath, "s", "", "scenario file (YAML or JSON)")
+	flag.StringVar(&logLevel, "l", "info", "log level")
+	flag.Parse()
+
+	if err := logger.Initialize(logLevel); err != nil {
+		log.Fatal(err, "initialize logger")
+	}
+
+	sc, err := loadScenario(scenarioPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := &stub{scenario: sc, state: newState()}
+
+	r := chi.NewRouter()
+	r.Use(logger.Middleware)
+	r.Get(`/api/orders/{number}`, s.orderHandler)
+
+	logger.Log.Info("Accrual stub started.", zap.String("address", address))
+	if err := http.ListenAndServe(address, r); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (s *stub) orderHandler(res http.ResponseWriter, req *http.Request) {
+	number := chi.URLParam(req, "number")
+
+	script, ok := s.scenario.Orders[number]
+	if !ok && s.scenario.Default != nil {
+		script, ok = *s.scenario.Default, true
+	}
+
+	latency := s.scenario.Latency
+	if script.Latency > 0 {
+		latency = script.Latency
+	}
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	if !s.state.allow(s.scenario.RateLimit.RequestsPerMinute) {
+		tooManyRequests(res, s.scenario.RateLimit)
+		return
+	}
+
+	if !ok || script.Unregistered {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	call := s.state.hit(number)
+	if call < script.TooManyRequests {
+		tooManyRequests(res, s.scenario.RateLimit)
+		return
+	}
+
+	info := model.AccrualInfo{
+		Order:  number,
+		Status: statusAt(script.Statuses, call-script.TooManyRequests),
+	}
+	if info.Status == "PROCESSED" && script.Accrual != nil {
+		accrual := model.MoneyFromFloat(*script.Accrual)
+		info.Accrual = &accrual
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(info); err != nil {
+		logger.Log.Error("error", zap.Error(err))
+	}
+}
+
+func statusAt(statuses []string, i int) string {
+	if len(statuses) == 0 {
+		return "PROCESSED"
+	}
+	if i >= len(statuses) {
+		i = len(statuses) - 1
+	}
+
+	return statuses[i]
+}
+
+func tooManyRequests(res http.ResponseWriter, limit RateLimit) {
+	retryAfter := limit.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+
+	res.Header().Set("Content-Type", "text/plain")
+	res.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	res.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(res, "No more than %d requests per minute allowed", limit.RequestsPerMinute)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario describes how the stub answers. JSON files are accepted as well,
+// since JSON is a subset of YAML.
+type Scenario struct {
+	Latency   time.Duration          `yaml:"latency"`
+	RateLimit RateLimit              `yaml:"rate_limit"`
+	Default   *OrderScript           `yaml:"default"`
+	Orders    map[string]OrderScript `yaml:"orders"`
+}
+
+// RateLimit makes the stub answer 429 once more than RequestsPerMinute
+// requests arrive within a minute.
+type RateLimit struct {
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	RetryAfter        int `yaml:"retry_after"`
+}
+
+// OrderScript is the behaviour of a single order. Every request moves the
+// order to the next status; the last one is repeated forever.
+type OrderScript struct {
+	Statuses        []string      `yaml:"statuses"`
+	Accrual         *float64      `yaml:"accrual"`
+	Latency         time.Duration `yaml:"latency"`
+	TooManyRequests int           `yaml:"too_many_requests"`
+	Unregistered    bool          `yaml:"unregistered"`
+}
+
+func loadScenario(path string) (*Scenario, error) {
+	sc := &Scenario{}
+	if path == "" {
+		return sc, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read scenario")
+	}
+
+	if err := yaml.Unmarshal(data, sc); err != nil {
+		return nil, errors.Wrap(err, "parse scenario")
+	}
+
+	return sc, nil
+}
+
+type orderState struct {
+	calls int
+}
+
+// state tracks per-order progress and the rate limit window.
+type state struct {
+	mu          sync.Mutex
+	orders      map[string]*orderState
+	windowStart time.Time
+	windowCount int
+}
+
+func newState() *state {
+	return &state{
+		orders: make(map[string]*orderState),
+	}
+}
+
+// hit registers a call for the order and returns its call number starting at 0.
+func (s *state) hit(number string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok {
+		o = &orderState{}
+		s.orders[number] = o
+	}
+	o.calls++
+
+	return o.calls - 1
+}
+
+// allow reports whether the request fits into the per-minute limit.
+func (s *state) allow(limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.windowStart) >= time.Minute {
+		s.windowStart = now
+		s.windowCount = 0
+	}
+	s.windowCount++
+
+	return s.windowCount <= limit
+}
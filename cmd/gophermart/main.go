@@ -2,16 +2,19 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/nbvehbq/go-loyalty-service/internal/accrual"
 	"github.com/nbvehbq/go-loyalty-service/internal/logger"
 	"github.com/nbvehbq/go-loyalty-service/internal/server"
 	"github.com/nbvehbq/go-loyalty-service/internal/session"
 	"github.com/nbvehbq/go-loyalty-service/internal/storage/postgres"
+	"github.com/nbvehbq/go-loyalty-service/internal/worker"
 )
 
 func main() {
@@ -28,13 +31,62 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	session := session.NewSessionStorage(ctx)
-	db, err := postgres.NewStorage(ctx, cfg.DSN)
+	if args := flag.Args(); len(args) > 0 && args[0] == "migrate" {
+		if err := migrate(ctx, cfg.DSN, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	db, err := postgres.NewStorage(ctx, cfg.DSN,
+		postgres.WithPointsTTL(cfg.PointsTTL),
+		postgres.WithExpiryNotice(cfg.PointsExpiryNotice),
+		postgres.WithTiers(cfg.Tiers),
+		postgres.WithIdempotencyLease(cfg.IdempotencyLease),
+	)
 	if err != nil {
 		log.Fatal(err, "connect to db")
 	}
 
-	server, err := server.NewServer(db, session, cfg)
+	var sessions server.SessionStorage
+	switch cfg.SessionStore {
+	case server.SessionStorePostgres:
+		sessions = postgres.NewSessionStorage(ctx, db, cfg.SessionTTL, cfg.SessionCleanup)
+	case server.SessionStoreToken:
+		keyring, err := session.NewKeyring(cfg.AuthKeys, cfg.AuthSigningKey)
+		if err != nil {
+			log.Fatal(err, "load auth keys")
+		}
+		sessions = session.NewTokenStorage(keyring, cfg.SessionTTL)
+	default:
+		sessions = session.NewSessionStorage(ctx, cfg.SessionTTL, cfg.SessionCleanup)
+	}
+
+	client := accrual.NewClient(cfg.AccrualAddress, accrual.BreakerConfig{
+		Failures:         cfg.BreakerFailures,
+		OpenTimeout:      cfg.BreakerOpenTimeout,
+		HalfOpenRequests: cfg.BreakerHalfOpen,
+	})
+
+	poller := worker.NewWorker(db, client, worker.Config{
+		Owner:     cfg.InstanceID,
+		Interval:  cfg.PollInterval,
+		LeaseTTL:  cfg.PollLeaseTTL,
+		Workers:   cfg.PollWorkers,
+		BatchSize: cfg.PollBatchSize,
+		Backoff: worker.Backoff{
+			Base:   cfg.BackoffBase,
+			Max:    cfg.BackoffMax,
+			Jitter: cfg.BackoffJitter,
+		},
+		MaxAge: cfg.OrderMaxAge,
+	})
+	go poller.Run(ctx)
+
+	go worker.NewExpirer(db, cfg.PointsExpiryEvery).Run(ctx)
+	go worker.NewPurger(db, cfg.IdempotencyTTL, cfg.IdempotencyPurge).Run(ctx)
+
+	server, err := server.NewServer(db, sessions, client, cfg)
 	if err != nil {
 		log.Fatal(err, "create server")
 	}
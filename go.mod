@@ -7,6 +7,8 @@ require (
 	github.com/go-chi/chi/v5 v5.1.0
 	go.uber.org/zap v1.27.0
 	golang.org/x/crypto v0.17.0
+	golang.org/x/time v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,138 @@
+package accrual
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nbvehbq/go-loyalty-service/internal/logger"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type BreakerConfig struct {
+	// Failures is the number of consecutive failures that opens the breaker.
+	Failures int
+	// OpenTimeout is how long the breaker stays open before trial requests.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of concurrent trial requests.
+	HalfOpenRequests int
+}
+
+// Breaker stops calls to the accrual system after repeated failures, so an
+// outage does not cost a full timeout for every order.
+type Breaker struct {
+	mu       sync.Mutex
+	cfg      BreakerConfig
+	state    State
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+func NewBreaker(cfg BreakerConfig) *Breaker {
+	return &Breaker{cfg: cfg}
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be
+// followed by Success, Failure or Release.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		b.setState(StateHalfOpen)
+		fallthrough
+	case StateHalfOpen:
+		if b.trials >= b.cfg.HalfOpenRequests {
+			return ErrCircuitOpen
+		}
+		b.trials++
+	}
+
+	return nil
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state == StateHalfOpen {
+		b.setState(StateClosed)
+	}
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.cfg.Failures {
+		b.openedAt = time.Now()
+		b.setState(StateOpen)
+	}
+}
+
+// Release gives back the trial slot of an allowed call that ended without an
+// outcome, such as a cancelled one.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// Available reports whether calls would currently be let through.
+func (b *Breaker) Available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state != StateOpen || time.Since(b.openedAt) >= b.cfg.OpenTimeout
+}
+
+func (b *Breaker) setState(state State) {
+	b.trials = 0
+	if b.state == state {
+		return
+	}
+
+	logger.Log.Warn("accrual circuit breaker state changed",
+		zap.Stringer("from", b.state), zap.Stringer("to", state), zap.Int("failures", b.failures))
+	b.state = state
+}
@@ -0,0 +1,109 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nbvehbq/go-loyalty-service/internal/model"
+	"github.com/pkg/errors"
+)
+
+const requestTimeout = time.Second * 10
+
+const (
+	StatusRegistered = "REGISTERED"
+	StatusInvalid    = "INVALID"
+	StatusProcessing = "PROCESSING"
+	StatusProcessed  = "PROCESSED"
+)
+
+var (
+	ErrOrderNotRegistered = errors.New("order not registered")
+	ErrTooManyRequests    = errors.New("too many requests")
+)
+
+type Client struct {
+	address string
+	http    *http.Client
+	limiter *Limiter
+	breaker *Breaker
+}
+
+func NewClient(address string, breaker BreakerConfig) *Client {
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+		address = "http://" + address
+	}
+
+	return &Client{
+		address: strings.TrimRight(address, "/"),
+		http:    &http.Client{Timeout: requestTimeout},
+		limiter: NewLimiter(),
+		breaker: NewBreaker(breaker),
+	}
+}
+
+// State is the state of the circuit breaker around the accrual system.
+func (c *Client) State() string {
+	return c.breaker.State().String()
+}
+
+// Available reports whether the circuit breaker lets calls through.
+func (c *Client) Available() bool {
+	return c.breaker.Available()
+}
+
+func (c *Client) GetOrder(ctx context.Context, number string) (*model.AccrualInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.address+"/api/orders/"+url.PathEscape(number), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create request")
+	}
+
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, errors.Wrap(err, "wait limiter")
+	}
+
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.breaker.Release()
+		} else {
+			c.breaker.Failure()
+		}
+		return nil, errors.Wrap(err, "do request")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		c.breaker.Failure()
+	} else {
+		c.breaker.Success()
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		var info model.AccrualInfo
+		if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+			return nil, errors.Wrap(err, "decode response")
+		}
+		return &info, nil
+	case http.StatusNoContent:
+		return nil, ErrOrderNotRegistered
+	case http.StatusTooManyRequests:
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		c.limiter.Throttle(parseRetryAfter(res.Header), parseRate(body))
+		return nil, ErrTooManyRequests
+	default:
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,120 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const defaultRetryAfter = time.Second * 60
+
+var rateRe = regexp.MustCompile(`(\d+)\s+requests?\s+per\s+(second|minute|hour)`)
+
+// Limiter throttles calls to the accrual system. It is shared by every
+// goroutine using the Client, so a single 429 pauses all of them.
+type Limiter struct {
+	mu          sync.Mutex
+	limiter     *rate.Limiter
+	pausedUntil time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		limiter: rate.NewLimiter(rate.Inf, 1),
+	}
+}
+
+// Wait blocks until a request is allowed or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		pause := time.Until(l.pausedUntil)
+		l.mu.Unlock()
+
+		if pause > 0 {
+			timer := time.NewTimer(pause)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			continue
+		}
+
+		if err := l.limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		l.mu.Lock()
+		paused := time.Now().Before(l.pausedUntil)
+		l.mu.Unlock()
+
+		if !paused {
+			return nil
+		}
+	}
+}
+
+// Throttle pauses all calls for retryAfter and, when limit is known,
+// restricts the request rate once the pause is over.
+func (l *Limiter) Throttle(retryAfter time.Duration, limit rate.Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(retryAfter); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+
+	if limit > 0 {
+		l.limiter.SetLimit(limit)
+	}
+}
+
+// parseRetryAfter reads the Retry-After header given either in seconds
+// or as an HTTP date.
+func parseRetryAfter(h http.Header) time.Duration {
+	value := h.Get("Retry-After")
+	if value == "" {
+		return defaultRetryAfter
+	}
+
+	if sec, err := strconv.Atoi(value); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+
+	return defaultRetryAfter
+}
+
+// parseRate reads the allowed rate from a body like
+// "No more than N requests per minute allowed".
+func parseRate(body []byte) rate.Limit {
+	m := rateRe.FindSubmatch(body)
+	if m == nil {
+		return 0
+	}
+
+	n, err := strconv.Atoi(string(m[1]))
+	if err != nil || n <= 0 {
+		return 0
+	}
+
+	per := time.Minute
+	switch string(m[2]) {
+	case "second":
+		per = time.Second
+	case "hour":
+		per = time.Hour
+	}
+
+	return rate.Every(per / time.Duration(n))
+}
@@ -0,0 +1,7 @@
+package model
+
+type AccrualInfo struct {
+	Order   string `json:"order"`
+	Status  string `json:"status"`
+	Accrual *Money `json:"accrual,omitempty"`
+}
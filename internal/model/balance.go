@@ -1,27 +1,14 @@
 package model
 
-import (
-	"database/sql"
-	"encoding/json"
-)
-
 type Balance struct {
-	Current  float64         `db:"current" json:"current"`
-	Windrawn sql.NullFloat64 `db:"windrawn" json:"windrawn"`
+	Current      Money   `db:"current" json:"current"`
+	Windrawn     Money   `db:"windrawn" json:"windrawn"`
+	ExpiringSoon Money   `db:"expiring_soon" json:"expiring_soon"`
+	NextExpiry   *string `db:"next_expiry" json:"next_expiry,omitempty"`
 }
 
-func (v Balance) MarshalJSON() ([]byte, error) {
-	type Balancelias Balance
-
-	value := v.Windrawn.Float64
-
-	aliasValue := struct {
-		Balancelias
-		Windrawn float64 `json:"windrawn"`
-	}{
-		Balancelias: (Balancelias)(v),
-		Windrawn:    value,
-	}
-
-	return json.Marshal(aliasValue)
+type TransferDTO struct {
+	UserID int64  `json:"-"`
+	Login  string `json:"login"`
+	Sum    Money  `json:"sum"`
 }
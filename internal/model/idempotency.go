@@ -0,0 +1,8 @@
+package model
+
+// IdempotentResponse is the response stored for an Idempotency-Key.
+type IdempotentResponse struct {
+	StatusCode  int    `db:"status_code"`
+	ContentType string `db:"content_type"`
+	Body        []byte `db:"response_body"`
+}
@@ -0,0 +1,29 @@
+package model
+
+const (
+	LedgerAccrual     = "accrual"
+	LedgerWithdrawal  = "withdrawal"
+	LedgerAdjustment  = "adjustment"
+	LedgerReversal    = "reversal"
+	LedgerExpiry      = "expiry"
+	LedgerTransferIn  = "transfer_in"
+	LedgerTransferOut = "transfer_out"
+	LedgerTierBonus   = "tier_bonus"
+)
+
+// LedgerEntry is an append-only record of a balance change. Balance is the
+// user balance right after the entry was applied.
+type LedgerEntry struct {
+	ID             int64  `db:"id" json:"-"`
+	UserID         int64  `db:"user_id" json:"-"`
+	Kind           string `db:"kind" json:"kind"`
+	Amount         Money  `db:"amount" json:"amount"`
+	Balance        Money  `db:"balance" json:"balance"`
+	OrderID        *int64 `db:"order_id" json:"-"`
+	WithdrawalID   *int64 `db:"withdrawal_id" json:"-"`
+	CounterpartyID *int64 `db:"counterparty_id" json:"-"`
+	Counterparty   string `db:"counterparty" json:"counterparty,omitempty"`
+	Order          string `db:"order" json:"order,omitempty"`
+	Note           string `db:"note" json:"note,omitempty"`
+	CreatedAt      string `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ListFilter narrows and pages user lists. Zero values are not applied.
+type ListFilter struct {
+	Limit  int
+	Cursor *Cursor
+	Status string
+	From   *time.Time
+	To     *time.Time
+}
+
+// Cursor points at the last row of a page ordered by (created_at, id) descending.
+type Cursor struct {
+	CreatedAt string
+	ID        int64
+}
+
+func (c Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt + "|" + strconv.FormatInt(c.ID, 10)))
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	createdAt, id, ok := strings.Cut(string(data), "|")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+
+	if _, err := time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	c := &Cursor{CreatedAt: createdAt}
+	if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return c, nil
+}
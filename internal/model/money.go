@@ -0,0 +1,163 @@
+package model
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Money is an amount of loyalty points kept in hundredths to avoid
+// floating point rounding. It is encoded as a number with two decimals
+// in JSON and as NUMERIC in the database.
+type Money int64
+
+func MoneyFromFloat(f float64) Money {
+	return Money(math.Round(f * 100))
+}
+
+// ParseMoney parses a decimal like "751", "751.5", "-0.05" or "7.515e2".
+// More than two significant decimals are rejected.
+func ParseMoney(s string) (Money, error) {
+	value := strings.TrimPrefix(s, "-")
+	negative := value != s
+
+	if mantissa, exp, ok := strings.Cut(strings.ToLower(value), "e"); ok {
+		expanded, err := expandExponent(mantissa, exp)
+		if err != nil {
+			return 0, errors.Wrapf(err, "parse money %q", s)
+		}
+		value = expanded
+	}
+
+	if value == "" || value == "." {
+		return 0, errors.Errorf("parse money %q: empty value", s)
+	}
+
+	whole, frac, _ := strings.Cut(value, ".")
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > 2 {
+		return 0, errors.Errorf("money %q has more than two decimals", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	if whole == "" {
+		whole = "0"
+	}
+
+	w, err := strconv.ParseUint(whole, 10, 63)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse money %q", s)
+	}
+	f, err := strconv.ParseUint(frac, 10, 8)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse money %q", s)
+	}
+	if w > math.MaxInt64/100 || w*100+f > math.MaxInt64 {
+		return 0, errors.Errorf("money %q is out of range", s)
+	}
+
+	m := Money(w*100 + f)
+	if negative {
+		m = -m
+	}
+
+	return m, nil
+}
+
+// maxExponent bounds exponents accepted by ParseMoney; larger ones are out of
+// range or have too many decimals anyway.
+const maxExponent = 32
+
+// expandExponent rewrites mantissa times ten to the power of exp as a plain
+// decimal, so it is checked the same way as one.
+func expandExponent(mantissa, exp string) (string, error) {
+	e, err := strconv.Atoi(exp)
+	if err != nil {
+		return "", errors.Wrap(err, "bad exponent")
+	}
+	if e > maxExponent || e < -maxExponent {
+		return "", errors.New("exponent is out of range")
+	}
+
+	whole, frac, _ := strings.Cut(mantissa, ".")
+	if whole+frac == "" {
+		return "", errors.New("empty mantissa")
+	}
+
+	digits := whole + frac
+	point := len(whole) + e
+	if point < 0 {
+		digits = strings.Repeat("0", -point) + digits
+		point = 0
+	}
+	if point > len(digits) {
+		digits += strings.Repeat("0", point-len(digits))
+	}
+
+	return digits[:point] + "." + digits[point:], nil
+}
+
+// Mul multiplies the amount by f rounding to the nearest hundredth.
+func (m Money) Mul(f float64) Money {
+	return Money(math.Round(float64(m) * f))
+}
+
+func (m Money) String() string {
+	v := int64(m)
+
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	value, err := ParseMoney(string(bytes.Trim(data, `"`)))
+	if err != nil {
+		return err
+	}
+
+	*m = value
+	return nil
+}
+
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		value, err := ParseMoney(v)
+		if err != nil {
+			return err
+		}
+		*m = value
+	case []byte:
+		return m.Scan(string(v))
+	case int64:
+		*m = Money(v * 100)
+	case float64:
+		*m = MoneyFromFloat(v)
+	default:
+		return errors.Errorf("cannot scan %T into Money", src)
+	}
+
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
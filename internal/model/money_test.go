@@ -0,0 +1,192 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "751", want: 75100},
+		{in: "751.5", want: 75150},
+		{in: "751.50", want: 75150},
+		{in: "751.500", want: 75150},
+		{in: "-0.05", want: -5},
+		{in: ".5", want: 50},
+		{in: "5.", want: 500},
+		{in: "7.515e2", want: 75150},
+		{in: "1E2", want: 10000},
+		{in: "1e+2", want: 10000},
+		{in: "125e-2", want: 125},
+		{in: "-1.5e1", want: -1500},
+		{in: "92233720368547758.07", want: 9223372036854775807},
+		{in: "", wantErr: true},
+		{in: ".", wantErr: true},
+		{in: "-", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "1.2.3", wantErr: true},
+		{in: "0.001", wantErr: true},
+		{in: "1.005e0", wantErr: true},
+		{in: "1e-3", wantErr: true},
+		{in: "1e20", wantErr: true},
+		{in: "1e1000", wantErr: true},
+		{in: "e2", wantErr: true},
+		{in: "1e", wantErr: true},
+		{in: "92233720368547758.08", wantErr: true},
+		{in: "92233720368547759", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMoney(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseMoney(%q) = %d, want error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMoney(%q) error: %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMoney(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{in: 0, want: "0.00"},
+		{in: 5, want: "0.05"},
+		{in: -5, want: "-0.05"},
+		{in: 75150, want: "751.50"},
+		{in: -75100, want: "-751.00"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     any
+		want    Money
+		wantErr bool
+	}{
+		{name: "string", src: "751.50", want: 75150},
+		{name: "bytes", src: []byte("-0.05"), want: -5},
+		{name: "int64", src: int64(7), want: 700},
+		{name: "float64", src: 0.1 + 0.2, want: 30},
+		{name: "bad string", src: "0.001", wantErr: true},
+		{name: "unsupported", src: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Money
+			err := got.Scan(tt.src)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Scan(%v) = %d, want error", tt.src, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Scan(%v) error: %v", tt.src, err)
+			}
+			if got != tt.want {
+				t.Errorf("Scan(%v) = %d, want %d", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyValue(t *testing.T) {
+	v, err := Money(-75150).Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "-751.50" {
+		t.Errorf("Value() = %v, want -751.50", v)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	type payload struct {
+		Sum     Money  `json:"sum"`
+		Accrual *Money `json:"accrual"`
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		want payload
+		out  string
+	}{
+		{name: "number", in: `{"sum":751.5,"accrual":1}`, want: payload{Sum: 75150, Accrual: ptr(100)}, out: `{"sum":751.50,"accrual":1.00}`},
+		{name: "string", in: `{"sum":"0.05"}`, want: payload{Sum: 5}, out: `{"sum":0.05,"accrual":null}`},
+		{name: "exponent", in: `{"sum":1.5e2}`, want: payload{Sum: 15000}, out: `{"sum":150.00,"accrual":null}`},
+		{name: "null", in: `{"sum":null,"accrual":null}`, want: payload{}, out: `{"sum":0.00,"accrual":null}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got payload
+			if err := json.Unmarshal([]byte(tt.in), &got); err != nil {
+				t.Fatalf("Unmarshal(%s) error: %v", tt.in, err)
+			}
+			if got.Sum != tt.want.Sum || !equalMoney(got.Accrual, tt.want.Accrual) {
+				t.Errorf("Unmarshal(%s) = %+v, want %+v", tt.in, got, tt.want)
+			}
+
+			out, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("Marshal error: %v", err)
+			}
+			if string(out) != tt.out {
+				t.Errorf("Marshal = %s, want %s", out, tt.out)
+			}
+		})
+	}
+
+	t.Run("null keeps value", func(t *testing.T) {
+		m := Money(100)
+		if err := json.Unmarshal([]byte("null"), &m); err != nil {
+			t.Fatal(err)
+		}
+		if m != 100 {
+			t.Errorf("got %d, want 100", m)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		var m Money
+		if err := json.Unmarshal([]byte("0.001"), &m); err == nil {
+			t.Errorf("want error, got %d", m)
+		}
+	})
+}
+
+func ptr(m Money) *Money {
+	return &m
+}
+
+func equalMoney(a, b *Money) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -1,31 +1,48 @@
 package model
 
-import (
-	"database/sql"
-	"encoding/json"
-)
-
 type Order struct {
-	ID        int64           `db:"id" json:"-"`
-	Number    string          `db:"number" json:"number"`
-	UserID    int64           `db:"user_id" json:"-"`
-	Status    string          `db:"status" json:"status"`
-	Accrual   sql.NullFloat64 `db:"accrual" json:"accrual,omitempty"`
-	CreatedAt string          `db:"created_at" json:"uploaded_at"`
+	ID        int64  `db:"id" json:"-"`
+	Number    string `db:"number" json:"number"`
+	UserID    int64  `db:"user_id" json:"-"`
+	Status    string `db:"status" json:"status"`
+	Accrual   *Money `db:"accrual" json:"accrual,omitempty"`
+	CreatedAt string `db:"created_at" json:"uploaded_at"`
+	Attempts  int    `db:"attempts" json:"-"`
 }
 
-func (v Order) MarshalJSON() ([]byte, error) {
-	type OrderAlias Order
+const (
+	PollSourcePoller   = "poller"
+	PollSourceCallback = "callback"
+)
 
-	value := v.Accrual.Float64
+// OrderPoll is a single answer about the order received from the accrual system.
+type OrderPoll struct {
+	ID          int64  `db:"id" json:"-"`
+	OrderNumber string `db:"-" json:"-"`
+	Source      string `db:"source" json:"source"`
+	Status      string `db:"status" json:"status,omitempty"`
+	Accrual     *Money `db:"accrual" json:"accrual,omitempty"`
+	Error       string `db:"error" json:"error,omitempty"`
+	CreatedAt   string `db:"created_at" json:"received_at"`
+}
 
-	aliasValue := struct {
-		OrderAlias
-		Accrual float64 `json:"accrual,omitempty"`
-	}{
-		OrderAlias: (OrderAlias)(v),
-		Accrual:    value,
-	}
+type OrderDetail struct {
+	Order
+	UpdatedAt string      `db:"updated_at" json:"updated_at"`
+	History   []OrderPoll `db:"-" json:"history"`
+}
+
+const (
+	UploadAccepted        = "accepted"
+	UploadAlreadyUploaded = "already_uploaded"
+	UploadOwnedByOther    = "owned_by_other"
+	UploadInvalid         = "invalid"
+	UploadError           = "error"
+)
 
-	return json.Marshal(aliasValue)
+// OrderUploadResult is the outcome of a single line of a bulk upload.
+type OrderUploadResult struct {
+	Line   int    `json:"line"`
+	Number string `json:"number"`
+	Result string `json:"result"`
 }
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// SessionMeta describes the client a session was created for.
+type SessionMeta struct {
+	UserAgent string
+	IP        string
+}
+
+type Session struct {
+	ID         string    `db:"id_hash" json:"id"`
+	UserAgent  string    `db:"user_agent" json:"user_agent"`
+	IP         string    `db:"ip" json:"ip"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	LastSeenAt time.Time `db:"last_seen_at" json:"last_seen_at"`
+	Current    bool      `db:"-" json:"current"`
+}
@@ -0,0 +1,104 @@
+package model
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Tier is a membership level reached once the accruals of the trailing
+// twelve months reach Threshold. Accruals of its members are multiplied
+// by Multiplier.
+type Tier struct {
+	Name       string  `json:"name"`
+	Threshold  Money   `json:"threshold"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+// Tiers are ordered by threshold, the lowest first.
+type Tiers []Tier
+
+// UnmarshalText parses tiers like "BRONZE:0:1,SILVER:1000:1.05,GOLD:5000:1.1".
+func (t *Tiers) UnmarshalText(text []byte) error {
+	var tiers Tiers
+	for _, item := range strings.Split(string(text), ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return errors.Errorf("invalid tier %q, want NAME:THRESHOLD:MULTIPLIER", item)
+		}
+
+		threshold, err := ParseMoney(parts[1])
+		if err != nil {
+			return errors.Wrapf(err, "tier %s threshold", parts[0])
+		}
+		multiplier, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || multiplier < 1 {
+			return errors.Errorf("tier %s multiplier must be a number not less than 1", parts[0])
+		}
+
+		tiers = append(tiers, Tier{Name: parts[0], Threshold: threshold, Multiplier: multiplier})
+	}
+
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].Threshold < tiers[j].Threshold
+	})
+
+	*t = tiers
+	return nil
+}
+
+// For returns the tier reached with the accrued points and the next one.
+// Either is nil when there is no such tier.
+func (t Tiers) For(accrued Money) (current, next *Tier) {
+	for i := range t {
+		if accrued < t[i].Threshold {
+			return current, &t[i]
+		}
+		current = &t[i]
+	}
+
+	return current, nil
+}
+
+// Multiplier of the tier reached with the accrued points.
+func (t Tiers) Multiplier(accrued Money) float64 {
+	if current, _ := t.For(accrued); current != nil {
+		return current.Multiplier
+	}
+
+	return 1
+}
+
+// TierStatus is the user tier and the progress to the next one.
+type TierStatus struct {
+	Tier          string  `json:"tier,omitempty"`
+	Multiplier    float64 `json:"multiplier"`
+	Accrued       Money   `json:"accrued"`
+	NextTier      string  `json:"next_tier,omitempty"`
+	NextThreshold *Money  `json:"next_threshold,omitempty"`
+	Remaining     *Money  `json:"remaining,omitempty"`
+}
+
+func NewTierStatus(tiers Tiers, accrued Money) *TierStatus {
+	status := &TierStatus{Multiplier: 1, Accrued: accrued}
+
+	current, next := tiers.For(accrued)
+	if current != nil {
+		status.Tier = current.Name
+		status.Multiplier = current.Multiplier
+	}
+	if next != nil {
+		remaining := next.Threshold - accrued
+		status.NextTier = next.Name
+		status.NextThreshold = &next.Threshold
+		status.Remaining = &remaining
+	}
+
+	return status
+}
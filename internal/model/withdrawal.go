@@ -1,15 +1,27 @@
 package model
 
+const (
+	WithdrawalCompleted = "COMPLETED"
+	WithdrawalReversed  = "REVERSED"
+)
+
 type Withdrawal struct {
-	ID        int64   `db:"id" json:"-"`
-	UserID    int64   `db:"user_id" json:"-"`
-	Order     string  `db:"order" json:"order"`
-	Sum       float64 `db:"sum" json:"sum"`
-	CreatedAt string  `db:"created_at" json:"processed_at"`
+	ID             int64   `db:"id" json:"-"`
+	UserID         int64   `db:"user_id" json:"-"`
+	Order          string  `db:"order" json:"order"`
+	Sum            Money   `db:"sum" json:"sum"`
+	Status         string  `db:"status" json:"status"`
+	CreatedAt      string  `db:"created_at" json:"processed_at"`
+	ReversedAt     *string `db:"reversed_at" json:"reversed_at,omitempty"`
+	ReversalReason string  `db:"reversal_reason" json:"reversal_reason,omitempty"`
 }
 
 type WithdrawalDTO struct {
-	UserID int64   `json:"-"`
-	Order  string  `json:"order"`
-	Sum    float64 `json:"sum"`
+	UserID int64  `json:"-"`
+	Order  string `json:"order"`
+	Sum    Money  `json:"sum"`
+}
+
+type ReversalDTO struct {
+	Reason string `json:"reason"`
 }
@@ -1,27 +1,41 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 type contextKeyType string
 
-const uidKey contextKeyType = "uid"
+const (
+	uidKey contextKeyType = "uid"
+	sidKey contextKeyType = "sid"
+)
 
-func Authenticator(s SessionStorage) func(http.Handler) http.Handler {
+// Authenticator resolves the session of the request. Every authenticated
+// request extends the session, so the session cookie is renewed with ttl.
+func Authenticator(s SessionStorage, ttl time.Duration) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			cookie, err := r.Cookie("session")
-			payload := r.Header.Get("Authorization")
+			payload := bearerToken(r.Header.Get("Authorization"))
 
 			var sid string
-			if errors.Is(err, http.ErrNoCookie) {
-				sid = payload
-			} else {
+			fromCookie := !errors.Is(err, http.ErrNoCookie)
+			if fromCookie {
 				sid = cookie.Value
+			} else {
+				sid = payload
 			}
 
 			uid, ok := s.Get(r.Context(), sid)
@@ -30,11 +44,90 @@ func Authenticator(s SessionStorage) func(http.Handler) http.Handler {
 				return
 			}
 
+			if fromCookie {
+				setCookie(w, sid, ttl)
+			}
+
 			ctx := r.Context()
 			ctx = context.WithValue(ctx, uidKey, uid)
+			ctx = context.WithValue(ctx, sidKey, sid)
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		}
 		return http.HandlerFunc(fn)
 	}
 }
+
+// bearerToken strips the Bearer scheme from the Authorization header. A value
+// without a scheme is taken as is.
+func bearerToken(header string) string {
+	scheme, token, ok := strings.Cut(header, " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+
+	return header
+}
+
+const (
+	signatureHeader = "X-Signature"
+
+	// maxSignedBodySize bounds the body read before its signature is checked.
+	maxSignedBodySize = 1 << 20
+)
+
+// SignatureVerifier rejects requests whose body is not signed with secret.
+// The signature is the hex encoded HMAC-SHA256 of the body, optionally
+// prefixed with "sha256=".
+func SignatureVerifier(secret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			signature, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(signatureHeader), "sha256="))
+			if err != nil {
+				http.Error(w, "invalid signature", http.StatusUnauthorized)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodySize))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(body)
+			if !hmac.Equal(signature, mac.Sum(nil)) {
+				http.Error(w, "invalid signature", http.StatusUnauthorized)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+const adminTokenHeader = "X-Admin-Token"
+
+// AdminAuthenticator lets through requests carrying the admin token.
+func AdminAuthenticator(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			payload := r.Header.Get(adminTokenHeader)
+			if subtle.ConstantTimeCompare([]byte(payload), []byte(token)) != 1 {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+import "testing"
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "bearer", header: "Bearer abc.def.ghi", want: "abc.def.ghi"},
+		{name: "lowercase scheme", header: "bearer abc", want: "abc"},
+		{name: "extra spaces", header: "Bearer   abc ", want: "abc"},
+		{name: "raw session id", header: "V1StGXR8_Z5jdHi6B-myT", want: "V1StGXR8_Z5jdHi6B-myT"},
+		{name: "other scheme", header: "Basic dXNlcjpwYXNz", want: "Basic dXNlcjpwYXNz"},
+		{name: "scheme only", header: "Bearer", want: "Bearer"},
+		{name: "empty", header: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bearerToken(tt.header); got != tt.want {
+				t.Errorf("bearerToken(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,22 +2,87 @@ package server
 
 import (
 	"flag"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v11"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"github.com/nbvehbq/go-loyalty-service/internal/model"
+	"github.com/pkg/errors"
 )
 
 const (
-	defaultServerAddress  = "http://localhost:8081"
-	defaultAccrualAddress = "http://localhost:8080"
-	defaultLogLevel       = "info"
+	SessionStoreMemory   = "memory"
+	SessionStorePostgres = "postgres"
+	SessionStoreToken    = "token"
+)
+
+const (
+	defaultServerAddress      = "http://localhost:8081"
+	defaultAccrualAddress     = "http://localhost:8080"
+	defaultLogLevel           = "info"
+	defaultPollInterval       = time.Second * 1
+	defaultPollWorkers        = 4
+	defaultPollBatchSize      = 100
+	defaultPollLeaseTTL       = time.Minute * 2
+	defaultBackoffBase        = time.Second * 1
+	defaultBackoffMax         = time.Minute * 10
+	defaultBackoffJitter      = 0.2
+	defaultOrderMaxAge        = time.Hour * 24 * 7
+	defaultBreakerFailures    = 5
+	defaultBreakerOpenTimeout = time.Second * 30
+	defaultBreakerHalfOpen    = 1
+	defaultPointsExpiryNotice = time.Hour * 24 * 30
+	defaultPointsExpiryEvery  = time.Hour
+	defaultTiers              = "BRONZE:0:1"
+	defaultSessionStore       = SessionStoreMemory
+	defaultSessionTTL         = time.Hour * 1
+	defaultSessionCleanup     = time.Minute * 10
+	defaultIdempotencyLease   = time.Minute * 1
+	defaultIdempotencyTTL     = time.Hour * 24
+	defaultIdempotencyPurge   = time.Hour
 )
 
 type Config struct {
-	ServerAddress  string `env:"RUN_ADDRESS"`
-	AccrualAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
-	DSN            string `env:"DATABASE_URI"`
-	LogLevel       string `env:"LOG_LEVEL"`
+	ServerAddress  string        `env:"RUN_ADDRESS"`
+	AccrualAddress string        `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	DSN            string        `env:"DATABASE_URI"`
+	LogLevel       string        `env:"LOG_LEVEL"`
+	PollInterval   time.Duration `env:"ACCRUAL_POLL_INTERVAL"`
+	PollWorkers    int           `env:"ACCRUAL_POLL_WORKERS"`
+	PollBatchSize  int           `env:"ACCRUAL_POLL_BATCH_SIZE"`
+	PollLeaseTTL   time.Duration `env:"ACCRUAL_POLL_LEASE_TTL"`
+	InstanceID     string        `env:"INSTANCE_ID"`
+	BackoffBase    time.Duration `env:"ACCRUAL_BACKOFF_BASE"`
+	BackoffMax     time.Duration `env:"ACCRUAL_BACKOFF_MAX"`
+	BackoffJitter  float64       `env:"ACCRUAL_BACKOFF_JITTER"`
+	OrderMaxAge    time.Duration `env:"ACCRUAL_ORDER_MAX_AGE"`
+
+	BreakerFailures    int           `env:"ACCRUAL_BREAKER_FAILURES"`
+	BreakerOpenTimeout time.Duration `env:"ACCRUAL_BREAKER_OPEN_TIMEOUT"`
+	BreakerHalfOpen    int           `env:"ACCRUAL_BREAKER_HALF_OPEN_REQUESTS"`
+
+	PointsTTL          time.Duration `env:"POINTS_TTL"`
+	PointsExpiryNotice time.Duration `env:"POINTS_EXPIRY_NOTICE"`
+	PointsExpiryEvery  time.Duration `env:"POINTS_EXPIRY_INTERVAL"`
+
+	Tiers model.Tiers `env:"LOYALTY_TIERS"`
+
+	SessionStore   string        `env:"SESSION_STORE"`
+	SessionTTL     time.Duration `env:"SESSION_TTL"`
+	SessionCleanup time.Duration `env:"SESSION_CLEANUP_INTERVAL"`
+
+	// AuthKeys are token signing keys by key ID, e.g. "k1:secret1,k2:secret2".
+	AuthKeys       map[string]string `env:"AUTH_KEYS"`
+	AuthSigningKey string            `env:"AUTH_SIGNING_KEY_ID"`
+
+	IdempotencyLease time.Duration `env:"IDEMPOTENCY_LEASE"`
+	IdempotencyTTL   time.Duration `env:"IDEMPOTENCY_KEY_TTL"`
+	IdempotencyPurge time.Duration `env:"IDEMPOTENCY_PURGE_INTERVAL"`
+
+	AccrualWebhookSecret string `env:"ACCRUAL_WEBHOOK_SECRET"`
+	AdminToken           string `env:"ADMIN_TOKEN"`
 }
 
 func NewConfig() (*Config, error) {
@@ -25,6 +90,29 @@ func NewConfig() (*Config, error) {
 		ServerAddress:  defaultServerAddress,
 		AccrualAddress: defaultAccrualAddress,
 		LogLevel:       defaultLogLevel,
+		PollInterval:   defaultPollInterval,
+		PollWorkers:    defaultPollWorkers,
+		PollBatchSize:  defaultPollBatchSize,
+		PollLeaseTTL:   defaultPollLeaseTTL,
+		BackoffBase:    defaultBackoffBase,
+		BackoffMax:     defaultBackoffMax,
+		BackoffJitter:  defaultBackoffJitter,
+		OrderMaxAge:    defaultOrderMaxAge,
+
+		BreakerFailures:    defaultBreakerFailures,
+		BreakerOpenTimeout: defaultBreakerOpenTimeout,
+		BreakerHalfOpen:    defaultBreakerHalfOpen,
+
+		PointsExpiryNotice: defaultPointsExpiryNotice,
+		PointsExpiryEvery:  defaultPointsExpiryEvery,
+
+		SessionStore:   defaultSessionStore,
+		SessionTTL:     defaultSessionTTL,
+		SessionCleanup: defaultSessionCleanup,
+
+		IdempotencyLease: defaultIdempotencyLease,
+		IdempotencyTTL:   defaultIdempotencyTTL,
+		IdempotencyPurge: defaultIdempotencyPurge,
 	}
 
 	flag.StringVar(&cfg.ServerAddress, "a", defaultServerAddress, "server address default http://localhost:8081")
@@ -34,13 +122,104 @@ func NewConfig() (*Config, error) {
 
 	flag.Parse()
 
+	if err := cfg.Tiers.UnmarshalText([]byte(defaultTiers)); err != nil {
+		return nil, err
+	}
+
 	if err := env.Parse(cfg); err != nil {
 		return nil, err
 	}
 
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	switch cfg.SessionStore {
+	case SessionStoreMemory, SessionStorePostgres:
+	case SessionStoreToken:
+		if _, ok := cfg.AuthKeys[cfg.AuthSigningKey]; !ok {
+			return nil, errors.Errorf("signing key %q not found in AUTH_KEYS", cfg.AuthSigningKey)
+		}
+	default:
+		return nil, errors.Errorf("unknown session store %q", cfg.SessionStore)
+	}
+
 	if strings.HasPrefix(cfg.ServerAddress, "http://") {
 		cfg.ServerAddress = strings.Replace(cfg.ServerAddress, "http://", "", -1)
 	}
 
+	if cfg.InstanceID == "" {
+		id, err := instanceID()
+		if err != nil {
+			return nil, err
+		}
+		cfg.InstanceID = id
+	}
+
 	return cfg, nil
 }
+
+// validate rejects values the service can not run with, such as intervals the
+// background jobs tick at and counts the poller and breaker rely on.
+func (cfg *Config) validate() error {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"ACCRUAL_POLL_INTERVAL", cfg.PollInterval},
+		{"ACCRUAL_POLL_LEASE_TTL", cfg.PollLeaseTTL},
+		{"POINTS_EXPIRY_INTERVAL", cfg.PointsExpiryEvery},
+		{"SESSION_TTL", cfg.SessionTTL},
+		{"SESSION_CLEANUP_INTERVAL", cfg.SessionCleanup},
+		{"IDEMPOTENCY_LEASE", cfg.IdempotencyLease},
+		{"IDEMPOTENCY_KEY_TTL", cfg.IdempotencyTTL},
+		{"IDEMPOTENCY_PURGE_INTERVAL", cfg.IdempotencyPurge},
+		{"ACCRUAL_BACKOFF_BASE", cfg.BackoffBase},
+		{"ACCRUAL_BACKOFF_MAX", cfg.BackoffMax},
+	}
+	for _, d := range durations {
+		if d.value <= 0 {
+			return errors.Errorf("%s must be positive, got %s", d.name, d.value)
+		}
+	}
+
+	if cfg.BackoffJitter < 0 || cfg.BackoffJitter >= 1 {
+		return errors.Errorf("ACCRUAL_BACKOFF_JITTER must be in [0, 1), got %g", cfg.BackoffJitter)
+	}
+	if cfg.BackoffMax < cfg.BackoffBase {
+		return errors.Errorf("ACCRUAL_BACKOFF_MAX %s is less than ACCRUAL_BACKOFF_BASE %s",
+			cfg.BackoffMax, cfg.BackoffBase)
+	}
+
+	counts := []struct {
+		name  string
+		value int
+	}{
+		{"ACCRUAL_POLL_WORKERS", cfg.PollWorkers},
+		{"ACCRUAL_POLL_BATCH_SIZE", cfg.PollBatchSize},
+		{"ACCRUAL_BREAKER_FAILURES", cfg.BreakerFailures},
+		{"ACCRUAL_BREAKER_HALF_OPEN_REQUESTS", cfg.BreakerHalfOpen},
+	}
+	for _, c := range counts {
+		if c.value <= 0 {
+			return errors.Errorf("%s must be positive, got %d", c.name, c.value)
+		}
+	}
+
+	return nil
+}
+
+// instanceID identifies this replica when it leases orders.
+func instanceID() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", errors.Wrap(err, "get hostname")
+	}
+
+	suffix, err := gonanoid.New(8)
+	if err != nil {
+		return "", errors.Wrap(err, "generate instance id")
+	}
+
+	return host + "-" + suffix, nil
+}
@@ -2,25 +2,72 @@ package server
 
 import (
 	"context"
-	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/nbvehbq/go-loyalty-service/internal/logger"
 	"github.com/nbvehbq/go-loyalty-service/internal/model"
+	"github.com/nbvehbq/go-loyalty-service/internal/session"
 	"github.com/nbvehbq/go-loyalty-service/internal/storage"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func setCookie(w http.ResponseWriter, payload string) {
+const (
+	maxListLimit = 1000
+	maxBatchSize = 1000
+
+	// maxBatchBodySize bounds the body of a bulk upload.
+	maxBatchBodySize = 1 << 20
+)
+
+var orderStatuses = map[string]bool{
+	"NEW":        true,
+	"PROCESSING": true,
+	"INVALID":    true,
+	"PROCESSED":  true,
+}
+
+var withdrawalStatuses = map[string]bool{
+	model.WithdrawalCompleted: true,
+	model.WithdrawalReversed:  true,
+}
+
+func setCookie(w http.ResponseWriter, payload string, ttl time.Duration) {
 	cookie := &http.Cookie{
 		Name:     "session",
 		Value:    payload,
 		Path:     "/",
-		MaxAge:   3600,
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+// clearCookie drops the session cookie, replacing the renewed one set by
+// Authenticator.
+func clearCookie(w http.ResponseWriter) {
+	w.Header().Del("Set-Cookie")
+
+	cookie := &http.Cookie{
+		Name:     "session",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteLaxMode,
@@ -62,13 +109,13 @@ func (s *Server) registerHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	sid, err := s.session.Set(req.Context(), userID)
+	sid, err := s.session.Set(req.Context(), userID, sessionMeta(req))
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	setCookie(res, sid)
+	setCookie(res, sid, s.sessionTTL)
 	res.Header().Set("Authorization", sid)
 
 	res.WriteHeader(http.StatusOK)
@@ -99,18 +146,102 @@ func (s *Server) loginHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	sid, err := s.session.Set(req.Context(), user.ID)
+	sid, err := s.session.Set(req.Context(), user.ID, sessionMeta(req))
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	setCookie(res, sid)
+	setCookie(res, sid, s.sessionTTL)
 	res.Header().Set("Authorization", sid)
 
 	res.WriteHeader(http.StatusOK)
 }
 
+func (s *Server) logoutHandler(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := s.session.Delete(ctx, SID(ctx)); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrSessionUnsupported):
+			http.Error(res, err.Error(), http.StatusNotImplemented)
+		default:
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	clearCookie(res)
+	res.WriteHeader(http.StatusOK)
+}
+
+// logoutAllHandler ends every session of the user, including the current one.
+func (s *Server) logoutAllHandler(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := s.session.DeleteAllForUser(ctx, UID(ctx)); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrSessionUnsupported):
+			http.Error(res, err.Error(), http.StatusNotImplemented)
+		default:
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	clearCookie(res)
+	res.WriteHeader(http.StatusOK)
+}
+
+// listSessionsHandler shows active sessions of the user; the one making the
+// request is marked as current.
+func (s *Server) listSessionsHandler(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	sessions, err := s.session.List(ctx, UID(ctx))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrSessionUnsupported):
+			http.Error(res, err.Error(), http.StatusNotImplemented)
+		default:
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	current := session.HashID(SID(ctx))
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(sessions); err != nil {
+		logger.Log.Error("error", zap.Error(err))
+	}
+}
+
+func (s *Server) deleteSessionHandler(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	id := chi.URLParam(req, "id")
+
+	if err := s.session.DeleteByID(ctx, UID(ctx), id); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrSessionNotFound):
+			http.Error(res, err.Error(), http.StatusNotFound)
+		case errors.Is(err, storage.ErrSessionUnsupported):
+			http.Error(res, err.Error(), http.StatusNotImplemented)
+		default:
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if id == session.HashID(SID(ctx)) {
+		clearCookie(res)
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
 func (s *Server) uploadOrderHandler(res http.ResponseWriter, req *http.Request) {
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
@@ -127,26 +258,155 @@ func (s *Server) uploadOrderHandler(res http.ResponseWriter, req *http.Request)
 	uid := UID(ctx)
 
 	if _, err := s.storage.CreateOrder(ctx, uid, string(body)); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		switch {
+		case errors.Is(err, storage.ErrOrderAlreadyUploaded):
 			res.WriteHeader(http.StatusOK)
-			return
+		case errors.Is(err, storage.ErrOrderOwnedByOther):
+			http.Error(res, err.Error(), http.StatusConflict)
+		default:
+			http.Error(res, err.Error(), http.StatusInternalServerError)
 		}
-		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	res.WriteHeader(http.StatusAccepted)
 }
 
+func (s *Server) uploadOrdersBatchHandler(res http.ResponseWriter, req *http.Request) {
+	var (
+		lines []orderLine
+		err   error
+	)
+
+	body := http.MaxBytesReader(res, req.Body, maxBatchBodySize)
+
+	mediaType, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		lines, err = readOrdersJSON(body)
+	case "text/csv":
+		lines, err = readOrdersCSV(body, params["header"] == "present")
+	default:
+		http.Error(res, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(res, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(lines) == 0 || len(lines) > maxBatchSize {
+		http.Error(res, fmt.Sprintf("batch must contain 1 to %d orders", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	ctx := req.Context()
+	uid := UID(ctx)
+
+	results := make([]model.OrderUploadResult, len(lines))
+	for i, line := range lines {
+		number := line.Number
+		results[i] = model.OrderUploadResult{Line: line.Line, Number: number}
+
+		if ok, _ := validateOrderID([]byte(number)); !ok {
+			results[i].Result = model.UploadInvalid
+			continue
+		}
+
+		_, err := s.storage.CreateOrder(ctx, uid, number)
+		switch {
+		case err == nil:
+			results[i].Result = model.UploadAccepted
+		case errors.Is(err, storage.ErrOrderAlreadyUploaded):
+			results[i].Result = model.UploadAlreadyUploaded
+		case errors.Is(err, storage.ErrOrderOwnedByOther):
+			results[i].Result = model.UploadOwnedByOther
+		default:
+			logger.Log.Error("create order", zap.String("order", number), zap.Error(err))
+			results[i].Result = model.UploadError
+		}
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(results); err != nil {
+		logger.Log.Error("error", zap.Error(err))
+	}
+}
+
+// orderLine is an order number of a bulk upload with the line it came from.
+type orderLine struct {
+	Line   int
+	Number string
+}
+
+// readOrdersJSON takes order numbers from an array; the line of a number is
+// its position in the array.
+func readOrdersJSON(r io.Reader) ([]orderLine, error) {
+	var numbers []string
+	if err := json.NewDecoder(r).Decode(&numbers); err != nil {
+		return nil, err
+	}
+
+	lines := make([]orderLine, len(numbers))
+	for i, number := range numbers {
+		lines[i] = orderLine{Line: i + 1, Number: number}
+	}
+
+	return lines, nil
+}
+
+// readOrdersCSV takes order numbers from the first column, with the lines of
+// the file they are on. The first record is skipped when header is set, as
+// for the "text/csv; header=present" content type. Reading stops past
+// maxBatchSize records.
+func readOrdersCSV(r io.Reader, header bool) ([]orderLine, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var lines []orderLine
+	for len(lines) <= maxBatchSize {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "read csv")
+		}
+
+		if header {
+			header = false
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		lines = append(lines, orderLine{Line: line, Number: strings.TrimSpace(record[0])})
+	}
+
+	return lines, nil
+}
+
 func (s *Server) listOrderHandler(res http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	uid := UID(ctx)
 
-	orders, err := s.storage.ListOrders(ctx, uid)
+	filter, err := parseListFilter(req, orderStatuses)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	orders, next, err := s.storage.ListOrders(ctx, uid, filter)
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	setNextLink(res, req, next)
 
 	if len(orders) == 0 {
 		res.WriteHeader(http.StatusNoContent)
@@ -158,6 +418,32 @@ func (s *Server) listOrderHandler(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func (s *Server) getOrderHandler(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	uid := UID(ctx)
+
+	order, err := s.storage.GetOrderDetail(ctx, chi.URLParam(req, "number"))
+	if err != nil {
+		if errors.Is(err, storage.ErrOrderNotFound) {
+			http.Error(res, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Orders of other users are reported as unknown to not disclose them.
+	if order.UserID != uid {
+		http.Error(res, storage.ErrOrderNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(order); err != nil {
+		logger.Log.Error("error", zap.Error(err))
+	}
+}
+
 func (s *Server) getBalanceHandler(res http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	uid := UID(ctx)
@@ -174,15 +460,69 @@ func (s *Server) getBalanceHandler(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func (s *Server) getTierHandler(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	uid := UID(ctx)
+
+	tier, err := s.storage.GetTierStatus(ctx, uid)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(tier); err != nil {
+		logger.Log.Error("error", zap.Error(err))
+	}
+}
+
+func (s *Server) balanceHistoryHandler(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	uid := UID(ctx)
+
+	from, err := parseDateParam(req, "from", false)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseDateParam(req, "to", true)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := s.storage.ListLedgerEntries(ctx, uid, from, to)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(entries) == 0 {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(entries); err != nil {
+		logger.Log.Error("error", zap.Error(err))
+	}
+}
+
 func (s *Server) listWithdrawalsHandler(res http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	uid := UID(ctx)
 
-	withdrawals, err := s.storage.ListWithdrawals(ctx, uid)
+	filter, err := parseListFilter(req, withdrawalStatuses)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	withdrawals, next, err := s.storage.ListWithdrawals(ctx, uid, filter)
 	if err != nil {
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	setNextLink(res, req, next)
 
 	if len(withdrawals) == 0 {
 		res.WriteHeader(http.StatusNoContent)
@@ -208,17 +548,137 @@ func (s *Server) withdrawHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if dto.Sum <= 0 {
+		http.Error(res, "sum must be positive", http.StatusBadRequest)
+		return
+	}
+
 	dto.UserID = uid
 	if err := s.storage.CreateWithdrawal(req.Context(), &dto); err != nil {
 		if errors.Is(err, storage.ErrBalanceInsufficient) {
 			http.Error(res, "", http.StatusPaymentRequired)
 			return
 		}
+		if errors.Is(err, storage.ErrWithdrawalExists) {
+			http.Error(res, err.Error(), http.StatusConflict)
+			return
+		}
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
+func (s *Server) reverseWithdrawalHandler(res http.ResponseWriter, req *http.Request) {
+	var dto model.ReversalDTO
+	if err := json.NewDecoder(req.Body).Decode(&dto); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if dto.Reason == "" {
+		http.Error(res, "reason is required", http.StatusBadRequest)
+		return
+	}
+
+	withdrawal, err := s.storage.ReverseWithdrawal(req.Context(), chi.URLParam(req, "order"), dto.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrWithdrawalNotFound):
+			http.Error(res, err.Error(), http.StatusNotFound)
+		case errors.Is(err, storage.ErrWithdrawalReversed):
+			http.Error(res, err.Error(), http.StatusConflict)
+		default:
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(withdrawal); err != nil {
+		logger.Log.Error("error", zap.Error(err))
+	}
+}
+
+func (s *Server) accrualCallbackHandler(res http.ResponseWriter, req *http.Request) {
+	var info model.AccrualInfo
+	if err := json.NewDecoder(req.Body).Decode(&info); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if info.Order == "" {
+		http.Error(res, "order is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.storage.UpdateOrderAccrual(req.Context(), &info); err != nil {
+		if errors.Is(err, storage.ErrUnknownStatus) {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.storage.RecordOrderPoll(req.Context(), &model.OrderPoll{
+		OrderNumber: info.Order,
+		Source:      model.PollSourceCallback,
+		Status:      info.Status,
+		Accrual:     info.Accrual,
+	}); err != nil {
+		logger.Log.Error("record order poll", zap.String("order", info.Order), zap.Error(err))
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) healthHandler(res http.ResponseWriter, req *http.Request) {
+	status := "ok"
+	if !s.accrual.Available() {
+		status = "degraded"
+	}
+
+	health := map[string]string{
+		"status":  status,
+		"accrual": s.accrual.State(),
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(health); err != nil {
+		logger.Log.Error("error", zap.Error(err))
+	}
+}
+
+func (s *Server) transferHandler(res http.ResponseWriter, req *http.Request) {
+	var dto model.TransferDTO
+	if err := json.NewDecoder(req.Body).Decode(&dto); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if dto.Login == "" || dto.Sum <= 0 {
+		http.Error(res, "login and positive sum are required", http.StatusBadRequest)
+		return
+	}
+
+	dto.UserID = UID(req.Context())
+	if err := s.storage.Transfer(req.Context(), &dto); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrBalanceInsufficient):
+			http.Error(res, "", http.StatusPaymentRequired)
+		case errors.Is(err, storage.ErrUserNotFound):
+			http.Error(res, err.Error(), http.StatusNotFound)
+		case errors.Is(err, storage.ErrTransferToSelf):
+			http.Error(res, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
 func luhn(s []byte) bool {
 	var sum int
 	for i := 0; i < len(s); i++ {
@@ -240,7 +700,113 @@ func UID(ctx context.Context) int64 {
 	return uid
 }
 
+// sessionMeta describes the client of the request for the sessions listing.
+func sessionMeta(req *http.Request) model.SessionMeta {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+
+	return model.SessionMeta{
+		UserAgent: req.UserAgent(),
+		IP:        ip,
+	}
+}
+
+func SID(ctx context.Context) string {
+	sid := ctx.Value(sidKey).(string)
+	return sid
+}
+
+// parseListFilter reads limit, cursor, status, from and to query parameters.
+// Only the given statuses are accepted.
+func parseListFilter(req *http.Request, statuses map[string]bool) (model.ListFilter, error) {
+	var filter model.ListFilter
+	query := req.URL.Query()
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			return filter, errors.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		filter.Limit = limit
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := model.DecodeCursor(value)
+		if err != nil {
+			return filter, err
+		}
+		filter.Cursor = cursor
+	}
+
+	if value := query.Get("status"); value != "" {
+		if !statuses[value] {
+			return filter, errors.Errorf("invalid status: %q", value)
+		}
+		filter.Status = value
+	}
+
+	var err error
+	if filter.From, err = parseDateParam(req, "from", false); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseDateParam(req, "to", true); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// setNextLink points the client at the next page of a list.
+func setNextLink(res http.ResponseWriter, req *http.Request, next *model.Cursor) {
+	if next == nil {
+		return
+	}
+
+	cursor := next.Encode()
+	query := req.URL.Query()
+	query.Set("cursor", cursor)
+
+	link := url.URL{Path: req.URL.Path, RawQuery: query.Encode()}
+	res.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, link.String()))
+	res.Header().Set("X-Next-Cursor", cursor)
+}
+
+// parseDateParam reads an RFC3339 time or a 2006-01-02 date from the query.
+// A date used as an upper bound includes the whole day.
+func parseDateParam(req *http.Request, name string, upper bool) (*time.Time, error) {
+	value := req.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, errors.Errorf("invalid %s: %q", name, value)
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return &t, nil
+}
+
 func validateOrderID(b []byte) (bool, int) {
+	if len(b) == 0 {
+		return false, http.StatusBadRequest
+	}
+
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false, http.StatusUnprocessableEntity
+		}
+	}
+
 	if !luhn(b) {
 		return false, http.StatusUnprocessableEntity
 	}
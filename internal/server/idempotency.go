@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/nbvehbq/go-loyalty-service/internal/logger"
+	"github.com/nbvehbq/go-loyalty-service/internal/model"
+	"github.com/nbvehbq/go-loyalty-service/internal/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recordingResponseWriter) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recordingResponseWriter) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// idempotent makes retries of a request carrying an Idempotency-Key header
+// replay the first response instead of running the handler again.
+// Server errors are not stored, so such requests may be retried.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(res, req)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(res, "idempotency key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(req.Method + " " + req.URL.Path + "\n"))
+		hash.Write(body)
+
+		ctx := req.Context()
+		uid := UID(ctx)
+
+		stored, err := s.storage.ReserveIdempotencyKey(ctx, uid, key, hex.EncodeToString(hash.Sum(nil)))
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrIdempotencyKeyMismatch):
+				http.Error(res, err.Error(), http.StatusUnprocessableEntity)
+			case errors.Is(err, storage.ErrIdempotencyKeyInProgress):
+				http.Error(res, err.Error(), http.StatusConflict)
+			default:
+				http.Error(res, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if stored != nil {
+			if stored.ContentType != "" {
+				res.Header().Set("Content-Type", stored.ContentType)
+			}
+			res.Header().Set(idempotentReplayedHeader, "true")
+			res.WriteHeader(stored.StatusCode)
+			res.Write(stored.Body)
+			return
+		}
+
+		// Release the key if the handler panics, so the request may be retried.
+		defer func() {
+			if p := recover(); p != nil {
+				if err := s.storage.ReleaseIdempotencyKey(ctx, uid, key); err != nil {
+					logger.Log.Error("release idempotency key", zap.String("key", key), zap.Error(err))
+				}
+				panic(p)
+			}
+		}()
+
+		rw := &recordingResponseWriter{ResponseWriter: res}
+		next(rw, req)
+
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+
+		if rw.status >= http.StatusInternalServerError {
+			err = s.storage.ReleaseIdempotencyKey(ctx, uid, key)
+		} else {
+			err = s.storage.SaveIdempotentResponse(ctx, uid, key, &model.IdempotentResponse{
+				StatusCode:  rw.status,
+				ContentType: rw.Header().Get("Content-Type"),
+				Body:        rw.body.Bytes(),
+			})
+		}
+		if err != nil {
+			logger.Log.Error("store idempotent response", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/chi/v5"
@@ -16,32 +17,57 @@ type Repository interface {
 	GetUserByLogin(ctx context.Context, login string) (*model.User, error)
 	CreateOrder(ctx context.Context, uid int64, order string) (int64, error)
 	GetOrderByNumber(ctx context.Context, number string) (*model.Order, error)
-	ListOrders(ctx context.Context, uid int64) ([]model.Order, error)
+	GetOrderDetail(ctx context.Context, number string) (*model.OrderDetail, error)
+	RecordOrderPoll(ctx context.Context, poll *model.OrderPoll) error
+	ListOrders(ctx context.Context, uid int64, filter model.ListFilter) ([]model.Order, *model.Cursor, error)
 	GetBalance(ctx context.Context, uid int64) (*model.Balance, error)
-	ListWithdrawals(ctx context.Context, uid int64) ([]model.Withdrawal, error)
+	GetTierStatus(ctx context.Context, uid int64) (*model.TierStatus, error)
+	ListLedgerEntries(ctx context.Context, uid int64, from, to *time.Time) ([]model.LedgerEntry, error)
+	ListWithdrawals(ctx context.Context, uid int64, filter model.ListFilter) ([]model.Withdrawal, *model.Cursor, error)
 	CreateWithdrawal(ctx context.Context, dto *model.WithdrawalDTO) error
+	Transfer(ctx context.Context, dto *model.TransferDTO) error
+	ReverseWithdrawal(ctx context.Context, order, reason string) (*model.Withdrawal, error)
+	UpdateOrderAccrual(ctx context.Context, info *model.AccrualInfo) error
+	ReserveIdempotencyKey(ctx context.Context, uid int64, key, hash string) (*model.IdempotentResponse, error)
+	SaveIdempotentResponse(ctx context.Context, uid int64, key string, res *model.IdempotentResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, uid int64, key string) error
 }
 
 type SessionStorage interface {
-	Set(context.Context, int64) (string, error)
+	Set(context.Context, int64, model.SessionMeta) (string, error)
 	Get(context.Context, string) (int64, bool)
+	Delete(context.Context, string) error
+	DeleteAllForUser(context.Context, int64) error
+	List(context.Context, int64) ([]model.Session, error)
+	DeleteByID(context.Context, int64, string) error
+}
+
+type AccrualHealth interface {
+	State() string
+	Available() bool
 }
 
 type Server struct {
 	srv     *http.Server
 	storage Repository
 	session SessionStorage
+	accrual AccrualHealth
 	DSN     string
+
+	sessionTTL time.Duration
 }
 
-func NewServer(storage Repository, session SessionStorage, cfg *Config) (*Server, error) {
+func NewServer(storage Repository, session SessionStorage, accrual AccrualHealth, cfg *Config) (*Server, error) {
 	r := chi.NewRouter()
 
 	s := &Server{
 		srv:     &http.Server{Addr: cfg.ServerAddress, Handler: r},
 		storage: storage,
 		session: session,
+		accrual: accrual,
 		DSN:     cfg.DSN,
+
+		sessionTTL: cfg.SessionTTL,
 	}
 
 	r.Use(logger.Middleware)
@@ -51,18 +77,47 @@ func NewServer(storage Repository, session SessionStorage, cfg *Config) (*Server
 	r.Group(func(r chi.Router) {
 		r.Post(`/api/user/register`, s.registerHandler)
 		r.Post(`/api/user/login`, s.loginHandler)
+		r.Get(`/api/health`, s.healthHandler)
 	})
 
+	// Accrual system callbacks, enabled when the shared secret is configured
+	if cfg.AccrualWebhookSecret != "" {
+		r.Group(func(r chi.Router) {
+			r.Use(SignatureVerifier(cfg.AccrualWebhookSecret))
+
+			r.Post(`/api/internal/accrual/callback`, s.accrualCallbackHandler)
+		})
+	}
+
+	// Admin routes, enabled when the admin token is configured
+	if cfg.AdminToken != "" {
+		r.Group(func(r chi.Router) {
+			r.Use(AdminAuthenticator(cfg.AdminToken))
+
+			r.Post(`/api/admin/withdrawals/{order}/reverse`, s.reverseWithdrawalHandler)
+		})
+	}
+
 	// Private routes
 	r.Group(func(r chi.Router) {
-		r.Use(Authenticator(s.session))
+		r.Use(Authenticator(s.session, cfg.SessionTTL))
+
+		r.Post(`/api/user/logout`, s.logoutHandler)
+		r.Post(`/api/user/logout-all`, s.logoutAllHandler)
+		r.Get(`/api/user/sessions`, s.listSessionsHandler)
+		r.Delete(`/api/user/sessions/{id}`, s.deleteSessionHandler)
 
 		r.Post(`/api/user/orders`, s.uploadOrderHandler)
 		r.Get(`/api/user/orders`, s.listOrderHandler)
+		r.Post(`/api/user/orders/batch`, s.uploadOrdersBatchHandler)
+		r.Get(`/api/user/orders/{number}`, s.getOrderHandler)
 
 		r.Get(`/api/user/balance`, s.getBalanceHandler)
+		r.Get(`/api/user/balance/history`, s.balanceHistoryHandler)
+		r.Get(`/api/user/tier`, s.getTierHandler)
 		r.Get(`/api/user/withdrawals`, s.listWithdrawalsHandler)
-		r.Post(`/api/user/balance/withdraw`, s.withdrawHandler)
+		r.Post(`/api/user/balance/withdraw`, s.idempotent(s.withdrawHandler))
+		r.Post(`/api/user/balance/transfer`, s.transferHandler)
 	})
 
 	return s, nil
@@ -2,40 +2,49 @@ package session
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
 	"sync"
 	"time"
 
 	gonanoid "github.com/matoous/go-nanoid/v2"
+	"github.com/nbvehbq/go-loyalty-service/internal/model"
+	"github.com/nbvehbq/go-loyalty-service/internal/storage"
 	"github.com/pkg/errors"
 )
 
-const (
-	sessionTTL    = time.Hour * 1
-	clearInterval = time.Minute * 10
-)
-
 type object struct {
-	id      int64
-	expires time.Time
+	id       int64
+	meta     model.SessionMeta
+	created  time.Time
+	lastSeen time.Time
+	expires  time.Time
 }
 
 type Session struct {
-	mu      sync.RWMutex
+	mu      sync.Mutex
 	storage map[string]object
+	ttl     time.Duration
 }
 
-func NewSessionStorage(ctx context.Context) *Session {
+// NewSessionStorage keeps sessions in memory. A session expires after ttl
+// without use; expired sessions are removed every clearInterval.
+func NewSessionStorage(ctx context.Context, ttl, clearInterval time.Duration) *Session {
 	s := Session{
-		mu:      sync.RWMutex{},
 		storage: make(map[string]object),
+		ttl:     ttl,
 	}
 
 	go func() {
+		ticker := time.NewTicker(clearInterval)
+		defer ticker.Stop()
+
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-time.After(clearInterval):
+			case <-ticker.C:
 				s.reduceSessions()
 			}
 		}
@@ -44,37 +53,116 @@ func NewSessionStorage(ctx context.Context) *Session {
 	return &s
 }
 
-func (s *Session) Set(_ context.Context, id int64) (string, error) {
+func (s *Session) Set(_ context.Context, id int64, meta model.SessionMeta) (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	sid, err := gonanoid.New()
+	sid, err := NewID()
 	if err != nil {
-		return "", errors.Wrap(err, "generate sid")
+		return "", err
 	}
+	now := time.Now()
 	s.storage[sid] = object{
-		id:      id,
-		expires: time.Now().Add(sessionTTL),
+		id:       id,
+		meta:     meta,
+		created:  now,
+		lastSeen: now,
+		expires:  now.Add(s.ttl),
 	}
 
 	return sid, nil
 }
 
+// Get returns the session user and extends the session by its TTL.
 func (s *Session) Get(_ context.Context, sid string) (int64, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	o, ok := s.storage[sid]
 	if !ok {
 		return 0, false
 	}
 
+	now := time.Now()
+	if now.After(o.expires) {
+		delete(s.storage, sid)
+		return 0, false
+	}
+
+	o.lastSeen = now
+	o.expires = now.Add(s.ttl)
+	s.storage[sid] = o
+
 	return o.id, true
 }
 
+func (s *Session) Delete(_ context.Context, sid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.storage, sid)
+
+	return nil
+}
+
+func (s *Session) DeleteAllForUser(_ context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.storage {
+		if v.id == id {
+			delete(s.storage, k)
+		}
+	}
+
+	return nil
+}
+
+// List returns active sessions of the user, most recently used first.
+func (s *Session) List(_ context.Context, id int64) ([]model.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	res := make([]model.Session, 0)
+	for k, v := range s.storage {
+		if v.id != id || now.After(v.expires) {
+			continue
+		}
+		res = append(res, model.Session{
+			ID:         HashID(k),
+			UserAgent:  v.meta.UserAgent,
+			IP:         v.meta.IP,
+			CreatedAt:  v.created,
+			LastSeenAt: v.lastSeen,
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].LastSeenAt.After(res[j].LastSeenAt)
+	})
+
+	return res, nil
+}
+
+// DeleteByID ends the session of the user with the public ID returned by List.
+func (s *Session) DeleteByID(_ context.Context, id int64, publicID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.storage {
+		if v.id == id && HashID(k) == publicID {
+			delete(s.storage, k)
+			return nil
+		}
+	}
+
+	return storage.ErrSessionNotFound
+}
+
 func (s *Session) reduceSessions() {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	now := time.Now()
 	for k, v := range s.storage {
@@ -83,3 +171,20 @@ func (s *Session) reduceSessions() {
 		}
 	}
 }
+
+// NewID generates a session ID.
+func NewID() (string, error) {
+	sid, err := gonanoid.New()
+	if err != nil {
+		return "", errors.Wrap(err, "generate sid")
+	}
+
+	return sid, nil
+}
+
+// HashID is the form a session ID is stored in, so a leaked store does not
+// leak usable sessions.
+func HashID(sid string) string {
+	sum := sha256.Sum256([]byte(sid))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,142 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbvehbq/go-loyalty-service/internal/model"
+)
+
+// makeToken builds a token from the given parts signed with key.
+func makeToken(t *testing.T, header tokenHeader, claims tokenClaims, key string) string {
+	t.Helper()
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+	return payload + "." + encoding.EncodeToString(sign([]byte(key), payload))
+}
+
+func TestTokenStorageGet(t *testing.T) {
+	ctx := context.Background()
+
+	// "old" was the signing key before rotating to "new" and still verifies.
+	keyring, err := NewKeyring(map[string]string{"old": "old-secret", "new": "new-secret"}, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewTokenStorage(keyring, time.Hour)
+
+	issued, err := s.Set(ctx, 42, model.SessionMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := tokenClaims{Sub: "42", Iat: time.Now().Unix(), Exp: time.Now().Add(time.Hour).Unix()}
+	parts := strings.Split(issued, ".")
+
+	tests := []struct {
+		name   string
+		token  string
+		wantID int64
+		wantOK bool
+	}{
+		{
+			name:   "issued",
+			token:  issued,
+			wantID: 42,
+			wantOK: true,
+		},
+		{
+			name:   "rotated out key",
+			token:  makeToken(t, tokenHeader{Alg: "HS256", Typ: "JWT", Kid: "old"}, valid, "old-secret"),
+			wantID: 42,
+			wantOK: true,
+		},
+		{
+			name:  "unknown kid",
+			token: makeToken(t, tokenHeader{Alg: "HS256", Typ: "JWT", Kid: "gone"}, valid, "new-secret"),
+		},
+		{
+			name:  "kid of another key",
+			token: makeToken(t, tokenHeader{Alg: "HS256", Typ: "JWT", Kid: "old"}, valid, "new-secret"),
+		},
+		{
+			name: "tampered payload",
+			token: parts[0] + "." + encoding.EncodeToString(
+				[]byte(`{"sub":"1","iat":0,"exp":9999999999}`)) + "." + parts[2],
+		},
+		{
+			name:  "tampered signature",
+			token: parts[0] + "." + parts[1] + "." + encoding.EncodeToString([]byte("signature")),
+		},
+		{
+			name:  "alg none",
+			token: makeToken(t, tokenHeader{Alg: "none", Typ: "JWT", Kid: "new"}, valid, "new-secret"),
+		},
+		{
+			name:  "alg HS512",
+			token: makeToken(t, tokenHeader{Alg: "HS512", Typ: "JWT", Kid: "new"}, valid, "new-secret"),
+		},
+		{
+			name: "expired",
+			token: makeToken(t, tokenHeader{Alg: "HS256", Typ: "JWT", Kid: "new"},
+				tokenClaims{Sub: "42", Iat: time.Now().Add(-2 * time.Hour).Unix(), Exp: time.Now().Add(-time.Hour).Unix()},
+				"new-secret"),
+		},
+		{
+			name: "bad subject",
+			token: makeToken(t, tokenHeader{Alg: "HS256", Typ: "JWT", Kid: "new"},
+				tokenClaims{Sub: "admin", Exp: valid.Exp}, "new-secret"),
+		},
+		{
+			name:  "not a token",
+			token: "V1StGXR8_Z5jdHi6B-myT",
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, ok := s.Get(ctx, tt.token)
+			if ok != tt.wantOK || id != tt.wantID {
+				t.Errorf("Get() = %d, %v, want %d, %v", id, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    map[string]string
+		signing string
+		wantErr bool
+	}{
+		{name: "valid", keys: map[string]string{"k1": "secret"}, signing: "k1"},
+		{name: "missing signing key", keys: map[string]string{"k1": "secret"}, signing: "k2", wantErr: true},
+		{name: "empty secret", keys: map[string]string{"k1": "secret", "k2": ""}, signing: "k1", wantErr: true},
+		{name: "no keys", signing: "k1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyring(tt.keys, tt.signing)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewKeyring() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
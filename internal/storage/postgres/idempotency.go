@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/nbvehbq/go-loyalty-service/internal/model"
+	"github.com/nbvehbq/go-loyalty-service/internal/storage"
+	"github.com/pkg/errors"
+)
+
+// ReserveIdempotencyKey claims the key for a request with the given hash.
+// It returns nil when the request should be processed, or the stored
+// response when the same request was already completed. A reservation left
+// without a response for longer than the idempotency lease is taken over,
+// so a request lost to a crash can be retried.
+func (s *Storage) ReserveIdempotencyKey(ctx context.Context, uid int64, key, hash string) (*model.IdempotentResponse, error) {
+	query := `INSERT INTO "idempotency_key" AS k (user_id, key, request_hash) VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, key) DO UPDATE SET reserved_at = now()
+	WHERE k.status_code IS NULL AND k.request_hash = EXCLUDED.request_hash
+		AND k.reserved_at < now() - $4::float8 * interval '1 second';`
+
+	res, err := s.db.ExecContext(ctx, query, uid, key, hash, s.idempotencyLease.Seconds())
+	if err != nil {
+		return nil, errors.Wrap(err, "reserve idempotency key")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 1 {
+		return nil, nil
+	}
+
+	var stored struct {
+		RequestHash string        `db:"request_hash"`
+		StatusCode  sql.NullInt64 `db:"status_code"`
+		ContentType string        `db:"content_type"`
+		Body        []byte        `db:"response_body"`
+	}
+	query = `SELECT request_hash, status_code, content_type, response_body
+	FROM "idempotency_key" WHERE user_id = $1 AND key = $2;`
+	if err := s.db.GetContext(ctx, &stored, query, uid, key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrIdempotencyKeyInProgress
+		}
+		return nil, errors.Wrap(err, "get idempotency key")
+	}
+
+	if stored.RequestHash != hash {
+		return nil, storage.ErrIdempotencyKeyMismatch
+	}
+	if !stored.StatusCode.Valid {
+		return nil, storage.ErrIdempotencyKeyInProgress
+	}
+
+	return &model.IdempotentResponse{
+		StatusCode:  int(stored.StatusCode.Int64),
+		ContentType: stored.ContentType,
+		Body:        stored.Body,
+	}, nil
+}
+
+// SaveIdempotentResponse stores the response produced for the reserved key.
+func (s *Storage) SaveIdempotentResponse(ctx context.Context, uid int64, key string, res *model.IdempotentResponse) error {
+	query := `UPDATE "idempotency_key" SET status_code = $1, content_type = $2, response_body = $3
+	WHERE user_id = $4 AND key = $5 AND status_code IS NULL;`
+
+	if _, err := s.db.ExecContext(ctx, query, res.StatusCode, res.ContentType, res.Body, uid, key); err != nil {
+		return errors.Wrap(err, "save idempotent response")
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets the key so the request can be retried.
+func (s *Storage) ReleaseIdempotencyKey(ctx context.Context, uid int64, key string) error {
+	query := `DELETE FROM "idempotency_key" WHERE user_id = $1 AND key = $2;`
+
+	if _, err := s.db.ExecContext(ctx, query, uid, key); err != nil {
+		return errors.Wrap(err, "release idempotency key")
+	}
+
+	return nil
+}
+
+// PurgeIdempotencyKeys deletes keys created more than ttl ago and reports how
+// many were deleted.
+func (s *Storage) PurgeIdempotencyKeys(ctx context.Context, ttl time.Duration) (int64, error) {
+	query := `DELETE FROM "idempotency_key" WHERE created_at < now() - $1::float8 * interval '1 second';`
+
+	res, err := s.db.ExecContext(ctx, query, ttl.Seconds())
+	if err != nil {
+		return 0, errors.Wrap(err, "purge idempotency keys")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "purge idempotency keys")
+	}
+
+	return n, nil
+}
@@ -0,0 +1,336 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jmoiron/sqlx"
+	"github.com/nbvehbq/go-loyalty-service/internal/model"
+	"github.com/nbvehbq/go-loyalty-service/internal/storage"
+	"github.com/pkg/errors"
+)
+
+// postEntry changes the user balance by e.Amount and appends the matching
+// ledger entry carrying the resulting balance. Every balance change goes
+// through it, inside the transaction of the operation being recorded.
+func postEntry(ctx context.Context, tx *sqlx.Tx, e *model.LedgerEntry) error {
+	query := `UPDATE "user" SET balance = balance + $1 WHERE id = $2 RETURNING balance;`
+	if err := tx.QueryRowContext(ctx, query, e.Amount, e.UserID).Scan(&e.Balance); err != nil {
+		var pqErr *pgconn.PgError
+		if errors.As(err, &pqErr) && pgerrcode.CheckViolation == pqErr.Code {
+			return storage.ErrBalanceInsufficient
+		}
+		return errors.Wrap(err, "update balance")
+	}
+
+	query = `INSERT INTO "ledger_entry" (user_id, kind, amount, balance, order_id, withdrawal_id, counterparty_id, note)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at;`
+	if err := tx.QueryRowContext(ctx, query, e.UserID, e.Kind, e.Amount, e.Balance,
+		e.OrderID, e.WithdrawalID, e.CounterpartyID, e.Note).Scan(&e.ID, &e.CreatedAt); err != nil {
+		return errors.Wrap(err, "append ledger entry")
+	}
+
+	return nil
+}
+
+// credit posts a positive entry and opens a lot for the credited points that
+// expires after the configured points TTL.
+func (s *Storage) credit(ctx context.Context, tx *sqlx.Tx, e *model.LedgerEntry) error {
+	if err := postEntry(ctx, tx, e); err != nil {
+		return err
+	}
+	if e.Amount <= 0 {
+		return nil
+	}
+
+	var expiresAt *time.Time
+	if s.pointsTTL > 0 {
+		t := time.Now().Add(s.pointsTTL)
+		expiresAt = &t
+	}
+
+	query := `INSERT INTO "points_lot" (user_id, ledger_entry_id, amount, remaining, expires_at)
+	VALUES ($1, $2, $3, $3, $4);`
+	if _, err := tx.ExecContext(ctx, query, e.UserID, e.ID, e.Amount, expiresAt); err != nil {
+		return errors.Wrap(err, "create points lot")
+	}
+
+	return nil
+}
+
+// lotSpend is the part of a lot consumed by a debit.
+type lotSpend struct {
+	LotID     int64       `db:"lot_id"`
+	Amount    model.Money `db:"amount"`
+	ExpiresAt *time.Time  `db:"expires_at"`
+	CreatedAt time.Time   `db:"created_at"`
+}
+
+// debit spends points of the oldest lots first, posts a negative entry and
+// records which lots it spent. It returns the spent parts of the lots.
+// Lots are locked before the user row, the same order the expiry job uses.
+func debit(ctx context.Context, tx *sqlx.Tx, e *model.LedgerEntry) ([]lotSpend, error) {
+	var lots []struct {
+		ID        int64       `db:"id"`
+		Remaining model.Money `db:"remaining"`
+		ExpiresAt *time.Time  `db:"expires_at"`
+		CreatedAt time.Time   `db:"created_at"`
+	}
+	query := `SELECT id, remaining, expires_at, created_at FROM "points_lot" WHERE user_id = $1 AND remaining > 0
+	ORDER BY created_at, id FOR UPDATE;`
+	if err := tx.SelectContext(ctx, &lots, query, e.UserID); err != nil {
+		return nil, errors.Wrap(err, "list points lots")
+	}
+
+	var spends []lotSpend
+	left := -e.Amount
+	for _, lot := range lots {
+		if left == 0 {
+			break
+		}
+
+		take := min(lot.Remaining, left)
+		query = `UPDATE "points_lot" SET remaining = remaining - $1 WHERE id = $2;`
+		if _, err := tx.ExecContext(ctx, query, take, lot.ID); err != nil {
+			return nil, errors.Wrap(err, "spend points lot")
+		}
+		spends = append(spends, lotSpend{
+			LotID:     lot.ID,
+			Amount:    take,
+			ExpiresAt: lot.ExpiresAt,
+			CreatedAt: lot.CreatedAt,
+		})
+		left -= take
+	}
+
+	if left > 0 {
+		return nil, storage.ErrBalanceInsufficient
+	}
+
+	if err := postEntry(ctx, tx, e); err != nil {
+		return nil, err
+	}
+
+	query = `INSERT INTO "points_lot_spend" (ledger_entry_id, lot_id, amount) VALUES ($1, $2, $3);`
+	for _, spend := range spends {
+		if _, err := tx.ExecContext(ctx, query, e.ID, spend.LotID, spend.Amount); err != nil {
+			return nil, errors.Wrap(err, "record lot spend")
+		}
+	}
+
+	return spends, nil
+}
+
+// creditSpends posts a positive entry and opens lots for the spent parts of
+// another user's lots, keeping their age and expiry, so moving points between
+// users does not extend their life.
+func creditSpends(ctx context.Context, tx *sqlx.Tx, e *model.LedgerEntry, spends []lotSpend) error {
+	if err := postEntry(ctx, tx, e); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO "points_lot" (user_id, ledger_entry_id, amount, remaining, expires_at, created_at)
+	VALUES ($1, $2, $3, $3, $4, $5);`
+	for _, spend := range spends {
+		if _, err := tx.ExecContext(ctx, query,
+			e.UserID, e.ID, spend.Amount, spend.ExpiresAt, spend.CreatedAt); err != nil {
+			return errors.Wrap(err, "create points lot")
+		}
+	}
+
+	return nil
+}
+
+// refund posts e, a positive entry undoing the debit entry debitID, and
+// returns the points to the lots that debit spent. Points of lots that expired
+// meanwhile are written off by the next expiry run. It reports false and
+// posts nothing when the debit has no recorded lots, as debits made before
+// lot spends were recorded.
+func refund(ctx context.Context, tx *sqlx.Tx, e *model.LedgerEntry, debitID int64) (bool, error) {
+	var spends []lotSpend
+	query := `SELECT s.lot_id, s.amount, p.expires_at, p.created_at
+	FROM "points_lot_spend" s JOIN "points_lot" p ON p.id = s.lot_id
+	WHERE s.ledger_entry_id = $1 ORDER BY s.lot_id FOR UPDATE OF p;`
+	if err := tx.SelectContext(ctx, &spends, query, debitID); err != nil {
+		return false, errors.Wrap(err, "list lot spends")
+	}
+	if len(spends) == 0 {
+		return false, nil
+	}
+
+	query = `UPDATE "points_lot" SET remaining = remaining + $1 WHERE id = $2;`
+	for _, spend := range spends {
+		if _, err := tx.ExecContext(ctx, query, spend.Amount, spend.LotID); err != nil {
+			return false, errors.Wrap(err, "restore points lot")
+		}
+	}
+
+	if err := postEntry(ctx, tx, e); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ExpirePoints writes off up to limit lots whose expiry passed and reports how
+// many were expired. Each lot is expired in its own transaction.
+func (s *Storage) ExpirePoints(ctx context.Context, limit int) (int, error) {
+	var expired int
+	for expired < limit {
+		ok, err := s.expireLot(ctx)
+		if err != nil {
+			return expired, err
+		}
+		if !ok {
+			break
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+func (s *Storage) expireLot(ctx context.Context) (bool, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	var lot struct {
+		ID        int64       `db:"id"`
+		UserID    int64       `db:"user_id"`
+		Remaining model.Money `db:"remaining"`
+	}
+	query := `SELECT id, user_id, remaining FROM "points_lot"
+	WHERE remaining > 0 AND expires_at <= now()
+	ORDER BY expires_at LIMIT 1 FOR UPDATE SKIP LOCKED;`
+	if err := tx.GetContext(ctx, &lot, query); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "get expired lot")
+	}
+
+	query = `UPDATE "points_lot" SET remaining = 0 WHERE id = $1;`
+	if _, err := tx.ExecContext(ctx, query, lot.ID); err != nil {
+		return false, errors.Wrap(err, "expire lot")
+	}
+
+	if err := postEntry(ctx, tx, &model.LedgerEntry{
+		UserID: lot.UserID,
+		Kind:   model.LedgerExpiry,
+		Amount: -lot.Remaining,
+		Note:   fmt.Sprintf("lot %d expired", lot.ID),
+	}); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, errors.Wrap(err, "commit")
+	}
+
+	return true, nil
+}
+
+// ListLedgerEntries returns the user balance history in chronological order.
+// Nil bounds are not applied; from is inclusive and to is exclusive.
+func (s *Storage) ListLedgerEntries(ctx context.Context, uid int64, from, to *time.Time) ([]model.LedgerEntry, error) {
+	var entries []model.LedgerEntry
+	query := `
+	SELECT l.id, l.user_id, l.kind, l.amount, l.balance, l.order_id, l.withdrawal_id, l.counterparty_id,
+		l.note, l.created_at, COALESCE(o.number, w."order", '') "order", COALESCE(u.login, '') counterparty
+	FROM "ledger_entry" l
+	LEFT JOIN "order" o ON o.id = l.order_id
+	LEFT JOIN "withdrawal" w ON w.id = l.withdrawal_id
+	LEFT JOIN "user" u ON u.id = l.counterparty_id
+	WHERE l.user_id = $1
+		AND ($2::timestamptz IS NULL OR l.created_at >= $2)
+		AND ($3::timestamptz IS NULL OR l.created_at < $3)
+	ORDER BY l.id;`
+
+	if err := s.db.SelectContext(ctx, &entries, query, uid, from, to); err != nil {
+		return nil, errors.Wrap(err, "list ledger entries")
+	}
+
+	return entries, nil
+}
+
+// Transfer moves points from the user to the recipient found by login. The
+// recipient gets the points with the expiry they had. Both sides are recorded in the ledger with each other as counterparty.
+func (s *Storage) Transfer(ctx context.Context, dto *model.TransferDTO) error {
+	return s.serializable(ctx, func(tx *sqlx.Tx) error {
+		var recipient int64
+		query := `SELECT id FROM "user" WHERE login = $1;`
+		if err := tx.GetContext(ctx, &recipient, query, dto.Login); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return storage.ErrUserNotFound
+			}
+			return errors.Wrap(err, "get recipient")
+		}
+
+		if recipient == dto.UserID {
+			return storage.ErrTransferToSelf
+		}
+
+		spends, err := debit(ctx, tx, &model.LedgerEntry{
+			UserID:         dto.UserID,
+			Kind:           model.LedgerTransferOut,
+			Amount:         -dto.Sum,
+			CounterpartyID: &recipient,
+		})
+		if err != nil {
+			return err
+		}
+
+		return creditSpends(ctx, tx, &model.LedgerEntry{
+			UserID:         recipient,
+			Kind:           model.LedgerTransferIn,
+			Amount:         dto.Sum,
+			CounterpartyID: &dto.UserID,
+		}, spends)
+	})
+}
+
+// serializable runs fn in a serializable transaction, retrying it when the
+// database reports a serialization failure or a deadlock.
+func (s *Storage) serializable(ctx context.Context, fn func(*sqlx.Tx) error) error {
+	const attempts = 3
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = s.runSerializable(ctx, fn); err == nil {
+			return nil
+		}
+
+		var pqErr *pgconn.PgError
+		if !errors.As(err, &pqErr) ||
+			(pqErr.Code != pgerrcode.SerializationFailure && pqErr.Code != pgerrcode.DeadlockDetected) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (s *Storage) runSerializable(ctx context.Context, fn func(*sqlx.Tx) error) error {
+	tx, err := s.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit")
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package postgres
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nbvehbq/go-loyalty-service/internal/model"
+)
+
+// listQuery appends the filter conditions, keyset ordering and limit to a
+// query selecting the user rows with "WHERE user_id = $1". One extra row is
+// requested to find out whether there is a next page.
+func listQuery(query string, uid int64, filter model.ListFilter) (string, []any) {
+	args := []any{uid}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	var b strings.Builder
+	b.WriteString(query)
+
+	if filter.Status != "" {
+		b.WriteString(" AND status = " + arg(filter.Status))
+	}
+	if filter.From != nil {
+		b.WriteString(" AND created_at >= " + arg(*filter.From))
+	}
+	if filter.To != nil {
+		b.WriteString(" AND created_at < " + arg(*filter.To))
+	}
+	if c := filter.Cursor; c != nil {
+		b.WriteString(" AND (created_at, id) < (" + arg(c.CreatedAt) + "::timestamptz, " + arg(c.ID) + ")")
+	}
+
+	b.WriteString(" ORDER BY created_at DESC, id DESC")
+
+	if filter.Limit > 0 {
+		b.WriteString(" LIMIT " + arg(filter.Limit+1))
+	}
+
+	return b.String(), args
+}
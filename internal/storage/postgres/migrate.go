@@ -0,0 +1,226 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// migrationLockID is the advisory lock key held while migrating, so only one
+// replica applies migrations at a time.
+const migrationLockID = 7325417790224711
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int        `db:"version"`
+	Name      string     `db:"name"`
+	AppliedAt *time.Time `db:"applied_at"`
+}
+
+// loadMigrations reads embedded files named like 0001_init.up.sql and
+// 0001_init.down.sql and returns them ordered by version.
+func loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return nil, errors.Wrap(err, "list migrations")
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, file := range files {
+		base := path.Base(file)
+
+		name, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, errors.Errorf("bad migration file name %q", base)
+		}
+
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, errors.Errorf("bad migration version %q", base)
+		}
+
+		body, err := migrationsFS.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "read migration")
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// MigrateUp applies every pending migration.
+func (s *Storage) MigrateUp(ctx context.Context) error {
+	return s.withMigrationLock(ctx, func(conn *sqlx.Conn, applied map[int]bool) error {
+		migrations, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if applied[m.Version] {
+				continue
+			}
+
+			query := `INSERT INTO schema_migrations (version, name) VALUES ($1, $2);`
+			if err := runMigration(ctx, conn, m.Up, query, m.Version, m.Name); err != nil {
+				return errors.Wrapf(err, "apply migration %s", m.Name)
+			}
+		}
+
+		return nil
+	})
+}
+
+// MigrateDown rolls back the latest applied migration.
+func (s *Storage) MigrateDown(ctx context.Context) error {
+	return s.withMigrationLock(ctx, func(conn *sqlx.Conn, applied map[int]bool) error {
+		migrations, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if !applied[m.Version] {
+				continue
+			}
+
+			query := `DELETE FROM schema_migrations WHERE version = $1;`
+			if err := runMigration(ctx, conn, m.Down, query, m.Version); err != nil {
+				return errors.Wrapf(err, "roll back migration %s", m.Name)
+			}
+			return nil
+		}
+
+		return nil
+	})
+}
+
+// MigrationStatus lists known migrations with the time they were applied.
+func (s *Storage) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	var res []MigrationStatus
+
+	err := s.withMigrationLock(ctx, func(conn *sqlx.Conn, _ map[int]bool) error {
+		var rows []MigrationStatus
+		query := `SELECT version, name, applied_at FROM schema_migrations;`
+		if err := conn.SelectContext(ctx, &rows, query); err != nil {
+			return errors.Wrap(err, "list applied migrations")
+		}
+
+		appliedAt := make(map[int]*time.Time, len(rows))
+		for _, row := range rows {
+			appliedAt[row.Version] = row.AppliedAt
+		}
+
+		migrations, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			res = append(res, MigrationStatus{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: appliedAt[m.Version],
+			})
+		}
+
+		return nil
+	})
+
+	return res, err
+}
+
+func (s *Storage) withMigrationLock(ctx context.Context, fn func(*sqlx.Conn, map[int]bool) error) error {
+	conn, err := s.db.Connx(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get connection")
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1);`, migrationLockID); err != nil {
+		return errors.Wrap(err, "acquire migration lock")
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1);`, migrationLockID)
+
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		CONSTRAINT "schema_migrations_pkey" PRIMARY KEY ("version")
+	);`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return errors.Wrap(err, "create schema_migrations")
+	}
+
+	var versions []int
+	if err := conn.SelectContext(ctx, &versions, `SELECT version FROM schema_migrations;`); err != nil {
+		return errors.Wrap(err, "list applied migrations")
+	}
+
+	applied := make(map[int]bool, len(versions))
+	for _, v := range versions {
+		applied[v] = true
+	}
+
+	return fn(conn, applied)
+}
+
+// runMigration executes the migration script and records it with query in
+// a single transaction.
+func runMigration(ctx context.Context, conn *sqlx.Conn, script, query string, args ...any) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return errors.Wrap(err, "record migration")
+	}
+
+	return tx.Commit()
+}
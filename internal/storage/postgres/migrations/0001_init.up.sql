@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS "user" (
+	id SERIAL NOT NULL,
+	login TEXT NOT NULL,
+	password_hash TEXT NOT NULL,
+	balance DOUBLE PRECISION NOT NULL DEFAULT 0.0,
+
+	CONSTRAINT "user_balance" CHECK (balance >= 0),
+	CONSTRAINT "user_id_pkey" PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS "user_login_key" ON "user"("login");
+
+CREATE TABLE IF NOT EXISTS "order" (
+	id SERIAL NOT NULL,
+	number TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	accrual DOUBLE PRECISION,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	CONSTRAINT "order_id_pkey" PRIMARY KEY ("id"),
+	CONSTRAINT "order_number_key" UNIQUE ("number")
+);
+
+CREATE INDEX IF NOT EXISTS "order_createdAt_idx" ON "order"(created_at DESC);
+
+ALTER TABLE "order" DROP CONSTRAINT IF EXISTS "order_user_fkey";
+ALTER TABLE "order" ADD CONSTRAINT "order_user_fkey" FOREIGN KEY ("user_id") REFERENCES "user"("id") ON DELETE SET NULL ON UPDATE CASCADE;
+
+CREATE TABLE IF NOT EXISTS "withdrawal" (
+	id SERIAL NOT NULL,
+	user_id INTEGER NOT NULL,
+	"order" TEXT NOT NULL,
+	sum INT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	CONSTRAINT "withdrawal_id_pkey" PRIMARY KEY ("id")
+);
+
+ALTER TABLE "withdrawal" DROP CONSTRAINT IF EXISTS "withdrawal_user_fkey";
+ALTER TABLE "withdrawal" ADD CONSTRAINT "withdrawal_user_fkey" FOREIGN KEY ("user_id") REFERENCES "user"("id") ON DELETE SET NULL ON UPDATE CASCADE;
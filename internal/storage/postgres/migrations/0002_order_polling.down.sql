@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS "order_next_attempt_idx";
+
+ALTER TABLE "order" DROP COLUMN IF EXISTS stuck_at;
+ALTER TABLE "order" DROP COLUMN IF EXISTS next_attempt_at;
+ALTER TABLE "order" DROP COLUMN IF EXISTS attempts;
+ALTER TABLE "order" DROP COLUMN IF EXISTS lease_until;
+ALTER TABLE "order" DROP COLUMN IF EXISTS lease_owner;
@@ -0,0 +1,8 @@
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS lease_owner TEXT;
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS lease_until TIMESTAMPTZ;
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS stuck_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS "order_next_attempt_idx" ON "order"(next_attempt_at)
+WHERE status IN ('NEW', 'PROCESSING');
@@ -0,0 +1,9 @@
+ALTER TABLE "withdrawal"
+	ALTER COLUMN sum TYPE INT USING round(sum);
+
+ALTER TABLE "order"
+	ALTER COLUMN accrual TYPE DOUBLE PRECISION;
+
+ALTER TABLE "user"
+	ALTER COLUMN balance TYPE DOUBLE PRECISION,
+	ALTER COLUMN balance SET DEFAULT 0.0;
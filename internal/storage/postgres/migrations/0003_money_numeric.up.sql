@@ -0,0 +1,9 @@
+ALTER TABLE "user"
+	ALTER COLUMN balance TYPE NUMERIC(14, 2) USING round(balance::numeric, 2),
+	ALTER COLUMN balance SET DEFAULT 0;
+
+ALTER TABLE "order"
+	ALTER COLUMN accrual TYPE NUMERIC(14, 2) USING round(accrual::numeric, 2);
+
+ALTER TABLE "withdrawal"
+	ALTER COLUMN sum TYPE NUMERIC(14, 2);
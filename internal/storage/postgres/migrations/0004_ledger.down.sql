@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "ledger_entry";
+DROP FUNCTION IF EXISTS ledger_entry_append_only();
@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS "ledger_entry" (
+	id BIGSERIAL NOT NULL,
+	user_id INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	amount NUMERIC(14, 2) NOT NULL,
+	balance NUMERIC(14, 2) NOT NULL,
+	order_id INTEGER,
+	withdrawal_id INTEGER,
+	note TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	CONSTRAINT "ledger_entry_id_pkey" PRIMARY KEY ("id"),
+	CONSTRAINT "ledger_entry_user_fkey" FOREIGN KEY ("user_id") REFERENCES "user"("id") ON UPDATE CASCADE,
+	CONSTRAINT "ledger_entry_order_fkey" FOREIGN KEY ("order_id") REFERENCES "order"("id"),
+	CONSTRAINT "ledger_entry_withdrawal_fkey" FOREIGN KEY ("withdrawal_id") REFERENCES "withdrawal"("id")
+);
+
+CREATE INDEX IF NOT EXISTS "ledger_entry_user_idx" ON "ledger_entry"(user_id, id);
+
+-- An order is credited at most once.
+CREATE UNIQUE INDEX IF NOT EXISTS "ledger_entry_accrual_key" ON "ledger_entry"(order_id)
+WHERE kind = 'accrual';
+
+CREATE OR REPLACE FUNCTION ledger_entry_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'ledger_entry is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER "ledger_entry_append_only" BEFORE UPDATE OR DELETE ON "ledger_entry"
+FOR EACH ROW EXECUTE FUNCTION ledger_entry_append_only();
+
+-- Backfill the history known so far.
+INSERT INTO "ledger_entry" (user_id, kind, amount, balance, order_id, withdrawal_id, note, created_at)
+SELECT user_id, kind, amount,
+	SUM(amount) OVER (PARTITION BY user_id ORDER BY created_at, order_id, withdrawal_id),
+	order_id, withdrawal_id, 'backfill', created_at
+FROM (
+	SELECT user_id, 'accrual' kind, accrual amount, id order_id, NULL::integer withdrawal_id, created_at
+	FROM "order" WHERE status = 'PROCESSED' AND accrual IS NOT NULL
+	UNION ALL
+	SELECT user_id, 'withdrawal', -sum, NULL, id, created_at
+	FROM "withdrawal"
+) e
+ORDER BY created_at, order_id, withdrawal_id;
+
+-- Reconcile with balances that are not explained by the history.
+INSERT INTO "ledger_entry" (user_id, kind, amount, balance, note)
+SELECT u.id, 'adjustment', u.balance - COALESCE(l.total, 0), u.balance, 'backfill reconciliation'
+FROM "user" u
+LEFT JOIN (
+	SELECT user_id, SUM(amount) total FROM "ledger_entry" GROUP BY user_id
+) l ON l.user_id = u.id
+WHERE u.balance <> COALESCE(l.total, 0);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "withdrawal_user_created_idx";
+DROP INDEX IF EXISTS "order_user_created_idx";
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS "order_user_created_idx" ON "order"(user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS "withdrawal_user_created_idx" ON "withdrawal"(user_id, created_at DESC, id DESC);
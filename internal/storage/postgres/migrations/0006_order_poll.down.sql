@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "order_poll";
+
+ALTER TABLE "order" DROP COLUMN IF EXISTS updated_at;
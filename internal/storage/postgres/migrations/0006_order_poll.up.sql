@@ -0,0 +1,18 @@
+ALTER TABLE "order" ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+UPDATE "order" SET updated_at = created_at;
+
+CREATE TABLE IF NOT EXISTS "order_poll" (
+	id BIGSERIAL NOT NULL,
+	order_id INTEGER NOT NULL,
+	source TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT '',
+	accrual NUMERIC(14, 2),
+	error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	CONSTRAINT "order_poll_id_pkey" PRIMARY KEY ("id"),
+	CONSTRAINT "order_poll_order_fkey" FOREIGN KEY ("order_id") REFERENCES "order"("id") ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS "order_poll_order_idx" ON "order_poll"(order_id, id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS "withdrawal_order_key";
+
+DROP TABLE IF EXISTS "idempotency_key";
@@ -0,0 +1,46 @@
+CREATE TABLE IF NOT EXISTS "idempotency_key" (
+	user_id INTEGER NOT NULL,
+	key TEXT NOT NULL,
+	request_hash TEXT NOT NULL,
+	status_code INTEGER,
+	content_type TEXT NOT NULL DEFAULT '',
+	response_body BYTEA,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	CONSTRAINT "idempotency_key_pkey" PRIMARY KEY ("user_id", "key"),
+	CONSTRAINT "idempotency_key_user_fkey" FOREIGN KEY ("user_id") REFERENCES "user"("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- Client retries could create several withdrawals for one order, each of them
+-- charging the user. A later withdrawal by the same user for the same sum as
+-- the first one for the order is such a retry: it is refunded, and 0008 marks
+-- it reversed. Other withdrawals reusing the order number were real debits and
+-- are kept. Either way their orders are renamed so the unique index can be built.
+DO $$
+DECLARE
+	dup RECORD;
+	new_balance NUMERIC(14, 2);
+BEGIN
+	FOR dup IN
+		SELECT w.id, w.user_id, w.sum, (f.user_id = w.user_id AND f.sum = w.sum) retry
+		FROM "withdrawal" w
+		JOIN LATERAL (
+			SELECT user_id, sum FROM "withdrawal"
+			WHERE "order" = w."order" ORDER BY id LIMIT 1
+		) f ON true
+		WHERE EXISTS (SELECT 1 FROM "withdrawal" e WHERE e."order" = w."order" AND e.id < w.id)
+		ORDER BY w.id
+	LOOP
+		IF dup.retry THEN
+			UPDATE "user" SET balance = balance + dup.sum WHERE id = dup.user_id
+			RETURNING balance INTO new_balance;
+
+			INSERT INTO "ledger_entry" (user_id, kind, amount, balance, withdrawal_id, note)
+			VALUES (dup.user_id, 'reversal', dup.sum, new_balance, dup.id, 'duplicate withdrawal');
+		END IF;
+
+		UPDATE "withdrawal" SET "order" = "order" || '-dup-' || id WHERE id = dup.id;
+	END LOOP;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS "withdrawal_order_key" ON "withdrawal"("order");
@@ -0,0 +1,3 @@
+ALTER TABLE "withdrawal" DROP COLUMN IF EXISTS reversal_reason;
+ALTER TABLE "withdrawal" DROP COLUMN IF EXISTS reversed_at;
+ALTER TABLE "withdrawal" DROP COLUMN IF EXISTS status;
@@ -0,0 +1,9 @@
+ALTER TABLE "withdrawal" ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'COMPLETED';
+ALTER TABLE "withdrawal" ADD COLUMN IF NOT EXISTS reversed_at TIMESTAMPTZ;
+ALTER TABLE "withdrawal" ADD COLUMN IF NOT EXISTS reversal_reason TEXT NOT NULL DEFAULT '';
+
+-- Retried withdrawals refunded by 0007; renamed ones that were not refunded
+-- stay completed.
+UPDATE "withdrawal" w SET status = 'REVERSED', reversed_at = l.created_at, reversal_reason = l.note
+FROM "ledger_entry" l
+WHERE l.withdrawal_id = w.id AND l.kind = 'reversal' AND l.note = 'duplicate withdrawal';
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS "points_lot" (
+	id BIGSERIAL NOT NULL,
+	user_id INTEGER NOT NULL,
+	ledger_entry_id BIGINT,
+	amount NUMERIC(14, 2) NOT NULL,
+	remaining NUMERIC(14, 2) NOT NULL,
+	expires_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	CONSTRAINT "points_lot_id_pkey" PRIMARY KEY ("id"),
+	CONSTRAINT "points_lot_remaining" CHECK (remaining >= 0 AND remaining <= amount),
+	CONSTRAINT "points_lot_user_fkey" FOREIGN KEY ("user_id") REFERENCES "user"("id") ON UPDATE CASCADE,
+	CONSTRAINT "points_lot_ledger_entry_fkey" FOREIGN KEY ("ledger_entry_id") REFERENCES "ledger_entry"("id")
+);
+
+CREATE INDEX IF NOT EXISTS "points_lot_user_idx" ON "points_lot"(user_id, expires_at, id)
+WHERE remaining > 0;
+
+CREATE INDEX IF NOT EXISTS "points_lot_expires_idx" ON "points_lot"(expires_at)
+WHERE remaining > 0 AND expires_at IS NOT NULL;
+
+-- Points earned before lots existed never expire.
+INSERT INTO "points_lot" (user_id, amount, remaining)
+SELECT id, balance, balance FROM "user" WHERE balance > 0;
@@ -0,0 +1,2 @@
+ALTER TABLE "ledger_entry" DROP CONSTRAINT IF EXISTS "ledger_entry_counterparty_fkey";
+ALTER TABLE "ledger_entry" DROP COLUMN IF EXISTS counterparty_id;
@@ -0,0 +1,4 @@
+ALTER TABLE "ledger_entry" ADD COLUMN IF NOT EXISTS counterparty_id INTEGER;
+
+ALTER TABLE "ledger_entry" ADD CONSTRAINT "ledger_entry_counterparty_fkey"
+FOREIGN KEY ("counterparty_id") REFERENCES "user"("id") ON UPDATE CASCADE;
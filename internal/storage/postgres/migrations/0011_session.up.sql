@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "session" (
+	id_hash TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	CONSTRAINT "session_id_hash_pkey" PRIMARY KEY ("id_hash"),
+	CONSTRAINT "session_user_fkey" FOREIGN KEY ("user_id") REFERENCES "user"("id") ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS "session_user_idx" ON "session"(user_id);
+CREATE INDEX IF NOT EXISTS "session_expires_idx" ON "session"(expires_at);
@@ -0,0 +1,4 @@
+ALTER TABLE "session"
+	ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS "idempotency_key_created_idx";
+
+ALTER TABLE "idempotency_key" DROP COLUMN IF EXISTS reserved_at;
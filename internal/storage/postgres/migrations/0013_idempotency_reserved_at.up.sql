@@ -0,0 +1,3 @@
+ALTER TABLE "idempotency_key" ADD COLUMN IF NOT EXISTS reserved_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS "idempotency_key_created_idx" ON "idempotency_key"(created_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS "points_lot_user_created_idx";
+
+DROP TABLE IF EXISTS "points_lot_spend";
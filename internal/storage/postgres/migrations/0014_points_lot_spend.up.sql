@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "points_lot_spend" (
+	ledger_entry_id BIGINT NOT NULL,
+	lot_id BIGINT NOT NULL,
+	amount NUMERIC(14, 2) NOT NULL,
+
+	CONSTRAINT "points_lot_spend_pkey" PRIMARY KEY ("ledger_entry_id", "lot_id"),
+	CONSTRAINT "points_lot_spend_ledger_entry_fkey" FOREIGN KEY ("ledger_entry_id") REFERENCES "ledger_entry"("id"),
+	CONSTRAINT "points_lot_spend_lot_fkey" FOREIGN KEY ("lot_id") REFERENCES "points_lot"("id")
+);
+
+CREATE INDEX IF NOT EXISTS "points_lot_user_created_idx" ON "points_lot"(user_id, created_at, id)
+WHERE remaining > 0;
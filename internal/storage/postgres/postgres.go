@@ -3,6 +3,7 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -22,76 +23,72 @@ const (
 )
 
 type Storage struct {
-	db *sqlx.DB
+	db           *sqlx.DB
+	pointsTTL    time.Duration
+	expiryNotice time.Duration
+	tiers        model.Tiers
+
+	idempotencyLease time.Duration
 }
 
-func NewStorage(ctx context.Context, DSN string) (*Storage, error) {
-	db, err := sqlx.ConnectContext(ctx, "pgx", DSN)
+type Option func(*Storage)
+
+// WithTiers sets the membership tiers applied to accruals.
+func WithTiers(tiers model.Tiers) Option {
+	return func(s *Storage) {
+		s.tiers = tiers
+	}
+}
+
+// WithIdempotencyLease sets how long an idempotency key may stay reserved
+// without a response before a retry takes it over.
+func WithIdempotencyLease(d time.Duration) Option {
+	return func(s *Storage) {
+		s.idempotencyLease = d
+	}
+}
+
+// WithPointsTTL makes credited points expire after ttl. Zero keeps them forever.
+func WithPointsTTL(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.pointsTTL = ttl
+	}
+}
+
+// WithExpiryNotice sets how far ahead the balance reports expiring points.
+func WithExpiryNotice(d time.Duration) Option {
+	return func(s *Storage) {
+		s.expiryNotice = d
+	}
+}
+
+// NewStorage connects to the database and applies pending migrations.
+func NewStorage(ctx context.Context, DSN string, opts ...Option) (*Storage, error) {
+	s, err := Connect(ctx, DSN, opts...)
 	if err != nil {
-		return nil, errors.Wrap(err, "connect to db")
+		return nil, err
 	}
 
-	if err := initDatabaseStructure(ctx, db); err != nil {
-		return nil, errors.Wrap(err, "init db")
+	if err := s.MigrateUp(ctx); err != nil {
+		return nil, errors.Wrap(err, "migrate db")
 	}
 
-	return &Storage{db: db}, nil
+	return s, nil
 }
 
-func initDatabaseStructure(ctx context.Context, db *sqlx.DB) error {
-	query := `
-	BEGIN TRANSACTION;
-
-	CREATE TABLE IF NOT EXISTS "user" (
-		id SERIAL NOT NULL,
-		login TEXT NOT NULL,
-		password_hash TEXT NOT NULL,
-		balance DOUBLE PRECISION NOT NULL DEFAULT 0.0,
-
-		CONSTRAINT "user_balance" CHECK (balance >= 0),
-		CONSTRAINT "user_id_pkey" PRIMARY KEY ("id")
-	);
-	
-	CREATE UNIQUE INDEX IF NOT EXISTS "user_login_key" ON "user"("login");
-
-	CREATE TABLE IF NOT EXISTS "order" (
-		id SERIAL NOT NULL,
-		number TEXT NOT NULL,
-		user_id INTEGER NOT NULL,
-		status TEXT NOT NULL,
-		accrual DOUBLE PRECISION,
-		created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
-
-		CONSTRAINT "order_id_pkey" PRIMARY KEY ("id"),
-		CONSTRAINT "order_number_key" UNIQUE ("number")
-	);
-
-	CREATE INDEX IF NOT EXISTS "order_createdAt_idx" ON "order"(created_at DESC);
-
-	ALTER TABLE "order" DROP CONSTRAINT IF EXISTS "order_user_fkey";
-	ALTER TABLE "order" ADD CONSTRAINT "order_user_fkey" FOREIGN KEY ("user_id") REFERENCES "user"("id") ON DELETE SET NULL ON UPDATE CASCADE;
-	
-	CREATE TABLE IF NOT EXISTS "withdrawal" (
-		id SERIAL NOT NULL,
-		user_id INTEGER NOT NULL,
-		"order" TEXT NOT NULL,
-		sum INT NOT NULL,
-		created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
-
-		CONSTRAINT "withdrawal_id_pkey" PRIMARY KEY ("id")
-	);
-
-	ALTER TABLE "withdrawal" DROP CONSTRAINT IF EXISTS "withdrawal_user_fkey";
-	ALTER TABLE "withdrawal" ADD CONSTRAINT "withdrawal_user_fkey" FOREIGN KEY ("user_id") REFERENCES "user"("id") ON DELETE SET NULL ON UPDATE CASCADE;
-
-	COMMIT;
-	`
-	_, err := db.ExecContext(ctx, query)
+// Connect connects to the database without touching the schema.
+func Connect(ctx context.Context, DSN string, opts ...Option) (*Storage, error) {
+	db, err := sqlx.ConnectContext(ctx, "pgx", DSN)
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, "connect to db")
 	}
 
-	return nil
+	s := &Storage{db: db}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
 }
 
 func (s *Storage) CreateUser(ctx context.Context, login, pass string) (int64, error) {
@@ -126,22 +123,84 @@ func (s *Storage) GetUserByLogin(ctx context.Context, login string) (*model.User
 }
 
 func (s *Storage) CreateOrder(ctx context.Context, uid int64, order string) (int64, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
 	var res int64
 	query := `INSERT INTO "order" (number, user_id, status) VALUES ($1, $2, $3)
 	ON CONFLICT DO NOTHING RETURNING id;`
 
-	if err := s.db.QueryRowContext(ctx, query, order, uid, StatusNew).Scan(&res); err != nil {
-		return 0, errors.Wrap(err, "create order")
+	if err := tx.QueryRowContext(ctx, query, order, uid, StatusNew).Scan(&res); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.Wrap(err, "create order")
+		}
+
+		existing, err := getOrderByNumber(ctx, tx, order)
+		if err != nil {
+			return 0, err
+		}
+		if existing.UserID != uid {
+			return 0, storage.ErrOrderOwnedByOther
+		}
+		return existing.ID, storage.ErrOrderAlreadyUploaded
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "commit")
 	}
 
 	return res, nil
 }
 
 func (s *Storage) GetOrderByNumber(ctx context.Context, number string) (*model.Order, error) {
+	return getOrderByNumber(ctx, s.db, number)
+}
+
+// GetOrderDetail returns the order with the answers received about it from
+// the accrual system, oldest first.
+func (s *Storage) GetOrderDetail(ctx context.Context, number string) (*model.OrderDetail, error) {
+	var order model.OrderDetail
+	query := `SELECT id, number, user_id, status, accrual, created_at, updated_at
+	FROM "order" WHERE number = $1;`
+
+	if err := s.db.GetContext(ctx, &order, query, number); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrOrderNotFound
+		}
+		return nil, errors.Wrap(err, "get order")
+	}
+
+	order.History = []model.OrderPoll{}
+	query = `SELECT id, source, status, accrual, error, created_at
+	FROM "order_poll" WHERE order_id = $1 ORDER BY id;`
+	if err := s.db.SelectContext(ctx, &order.History, query, order.ID); err != nil {
+		return nil, errors.Wrap(err, "list order polls")
+	}
+
+	return &order, nil
+}
+
+// RecordOrderPoll appends an accrual system answer to the order history.
+func (s *Storage) RecordOrderPoll(ctx context.Context, poll *model.OrderPoll) error {
+	query := `INSERT INTO "order_poll" (order_id, source, status, accrual, error)
+	SELECT id, $2, $3, $4, $5 FROM "order" WHERE number = $1;`
+
+	if _, err := s.db.ExecContext(ctx, query,
+		poll.OrderNumber, poll.Source, poll.Status, poll.Accrual, poll.Error); err != nil {
+		return errors.Wrap(err, "record order poll")
+	}
+
+	return nil
+}
+
+func getOrderByNumber(ctx context.Context, q sqlx.QueryerContext, number string) (*model.Order, error) {
 	var order model.Order
 	query := `SELECT id, number, user_id, status, accrual, created_at FROM "order" WHERE number = $1;`
 
-	if err := s.db.GetContext(ctx, &order, query, number); err != nil {
+	if err := sqlx.GetContext(ctx, q, &order, query, number); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, storage.ErrOrderNotFound
 		}
@@ -151,65 +210,93 @@ func (s *Storage) GetOrderByNumber(ctx context.Context, number string) (*model.O
 	return &order, nil
 }
 
-func (s *Storage) ListOrders(ctx context.Context, uid int64) ([]model.Order, error) {
+// ListOrders returns a page of user orders, newest first, and the cursor of
+// the next page if there is one.
+func (s *Storage) ListOrders(ctx context.Context, uid int64, filter model.ListFilter) ([]model.Order, *model.Cursor, error) {
 	var orders []model.Order
-	query := `SELECT id, number, user_id, status, accrual, created_at FROM "order" 
-	WHERE user_id = $1 ORDER BY created_at DESC;`
+	query, args := listQuery(`SELECT id, number, user_id, status, accrual, created_at FROM "order"
+	WHERE user_id = $1`, uid, filter)
 
-	if err := s.db.SelectContext(ctx, &orders, query, uid); err != nil {
-		return nil, errors.Wrap(err, "list orders")
+	if err := s.db.SelectContext(ctx, &orders, query, args...); err != nil {
+		return nil, nil, errors.Wrap(err, "list orders")
 	}
 
-	return orders, nil
+	if filter.Limit > 0 && len(orders) > filter.Limit {
+		orders = orders[:filter.Limit]
+		last := orders[len(orders)-1]
+		return orders, &model.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}, nil
+	}
+
+	return orders, nil, nil
 }
 
 func (s *Storage) GetBalance(ctx context.Context, uid int64) (*model.Balance, error) {
 	var balance model.Balance
 	query := `
-	SELECT u.balance as "current", w.windrawn FROM "user" u
-	LEFT JOIN (
-		SELECT user_id, SUM(sum) windrawn FROM "withdrawal" WHERE user_id = $1 GROUP BY user_id
-	) w ON w.user_id = u.id;`
-
-	if err := s.db.GetContext(ctx, &balance, query, uid); err != nil {
+	SELECT l.current, l.windrawn, p.expiring_soon, p.next_expiry
+	FROM (
+		SELECT COALESCE(SUM(amount), 0) "current",
+			COALESCE(-SUM(amount) FILTER (WHERE kind IN ($2, $3)), 0) windrawn
+		FROM "ledger_entry" WHERE user_id = $1
+	) l, (
+		SELECT COALESCE(SUM(remaining) FILTER (
+				WHERE expires_at <= now() + $4::float8 * interval '1 second'), 0) expiring_soon,
+			MIN(expires_at) next_expiry
+		FROM "points_lot" WHERE user_id = $1 AND remaining > 0
+	) p;`
+
+	if err := s.db.GetContext(ctx, &balance, query, uid,
+		model.LedgerWithdrawal, model.LedgerReversal, s.expiryNotice.Seconds()); err != nil {
 		return nil, errors.Wrap(err, "get balance")
 	}
 
 	return &balance, nil
 }
 
-func (s *Storage) ListWithdrawals(ctx context.Context, uid int64) ([]model.Withdrawal, error) {
+// ListWithdrawals returns a page of user withdrawals, newest first, and the
+// cursor of the next page if there is one.
+func (s *Storage) ListWithdrawals(ctx context.Context, uid int64, filter model.ListFilter) ([]model.Withdrawal, *model.Cursor, error) {
 	var withdrawals []model.Withdrawal
-	query := `SELECT id, user_id, "order", sum, created_at 
-	FROM "withdrawal" WHERE user_id = $1 ORDER BY created_at DESC;`
+	query, args := listQuery(`SELECT id, user_id, "order", sum, status, created_at, reversed_at, reversal_reason
+	FROM "withdrawal" WHERE user_id = $1`, uid, filter)
 
-	if err := s.db.SelectContext(ctx, &withdrawals, query, uid); err != nil {
-		return nil, errors.Wrap(err, "list withdrawals")
+	if err := s.db.SelectContext(ctx, &withdrawals, query, args...); err != nil {
+		return nil, nil, errors.Wrap(err, "list withdrawals")
 	}
 
-	return withdrawals, nil
+	if filter.Limit > 0 && len(withdrawals) > filter.Limit {
+		withdrawals = withdrawals[:filter.Limit]
+		last := withdrawals[len(withdrawals)-1]
+		return withdrawals, &model.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}, nil
+	}
+
+	return withdrawals, nil, nil
 }
 
 func (s *Storage) CreateWithdrawal(ctx context.Context, dto *model.WithdrawalDTO) error {
-	tx, err := s.db.Begin()
-	defer tx.Rollback()
-
-	query := `INSERT INTO "withdrawal" (user_id, "order", sum) VALUES ($1, $2, $3);`
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return errors.Wrap(err, "begin transaction")
 	}
+	defer tx.Rollback()
 
-	if _, err := tx.ExecContext(ctx, query, dto.UserID, dto.Order, dto.Sum); err != nil {
+	var id int64
+	query := `INSERT INTO "withdrawal" (user_id, "order", sum) VALUES ($1, $2, $3) RETURNING id;`
+	if err := tx.QueryRowContext(ctx, query, dto.UserID, dto.Order, dto.Sum).Scan(&id); err != nil {
+		var pqErr *pgconn.PgError
+		if errors.As(err, &pqErr) && pgerrcode.UniqueViolation == pqErr.Code {
+			return storage.ErrWithdrawalExists
+		}
 		return errors.Wrap(err, "create withdrawal")
 	}
 
-	query = `UPDATE "user" SET balance = balance - $1 WHERE id = $2;`
-	if _, err := tx.ExecContext(ctx, query, dto.Sum, dto.UserID); err != nil {
-		var pqErr *pgconn.PgError
-		if errors.As(err, &pqErr) && pgerrcode.CheckViolation == pqErr.Code {
-			return storage.ErrBalanceInsufficient
-		}
-		return errors.Wrap(err, "update balance")
+	if _, err := debit(ctx, tx, &model.LedgerEntry{
+		UserID:       dto.UserID,
+		Kind:         model.LedgerWithdrawal,
+		Amount:       -dto.Sum,
+		WithdrawalID: &id,
+	}); err != nil {
+		return err
 	}
 
 	err = tx.Commit()
@@ -219,3 +306,202 @@ func (s *Storage) CreateWithdrawal(ctx context.Context, dto *model.WithdrawalDTO
 
 	return nil
 }
+
+// ReverseWithdrawal marks the withdrawal made for the order as reversed and
+// returns the points to the lots they were spent from in the same transaction.
+// Withdrawals made before lot spends were recorded are credited as new points.
+func (s *Storage) ReverseWithdrawal(ctx context.Context, order, reason string) (*model.Withdrawal, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	var withdrawal model.Withdrawal
+	query := `SELECT id, user_id, "order", sum, status, created_at, reversed_at, reversal_reason
+	FROM "withdrawal" WHERE "order" = $1 FOR UPDATE;`
+	if err := tx.GetContext(ctx, &withdrawal, query, order); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrWithdrawalNotFound
+		}
+		return nil, errors.Wrap(err, "get withdrawal")
+	}
+
+	if withdrawal.Status == model.WithdrawalReversed {
+		return nil, storage.ErrWithdrawalReversed
+	}
+
+	query = `UPDATE "withdrawal" SET status = $1, reversed_at = now(), reversal_reason = $2
+	WHERE id = $3 RETURNING status, reversed_at, reversal_reason;`
+	if err := tx.QueryRowContext(ctx, query, model.WithdrawalReversed, reason, withdrawal.ID).
+		Scan(&withdrawal.Status, &withdrawal.ReversedAt, &withdrawal.ReversalReason); err != nil {
+		return nil, errors.Wrap(err, "reverse withdrawal")
+	}
+
+	var debitID int64
+	query = `SELECT id FROM "ledger_entry" WHERE withdrawal_id = $1 AND kind = $2;`
+	if err := tx.GetContext(ctx, &debitID, query, withdrawal.ID, model.LedgerWithdrawal); err != nil {
+		return nil, errors.Wrap(err, "get withdrawal entry")
+	}
+
+	entry := &model.LedgerEntry{
+		UserID:       withdrawal.UserID,
+		Kind:         model.LedgerReversal,
+		Amount:       withdrawal.Sum,
+		WithdrawalID: &withdrawal.ID,
+		Note:         reason,
+	}
+	refunded, err := refund(ctx, tx, entry, debitID)
+	if err != nil {
+		return nil, err
+	}
+	if !refunded {
+		if err := s.credit(ctx, tx, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+
+	return &withdrawal, nil
+}
+
+// ClaimOrders leases up to limit unprocessed orders to owner. Rows locked by
+// another replica are skipped, and orders whose lease expired are claimed again,
+// so a crashed replica does not keep its orders forever.
+func (s *Storage) ClaimOrders(ctx context.Context, owner string, lease time.Duration, limit int) ([]model.Order, error) {
+	var orders []model.Order
+	query := `
+	UPDATE "order" o SET
+		lease_owner = $1,
+		lease_until = now() + $2::float8 * interval '1 second'
+	FROM (
+		SELECT id FROM "order"
+		WHERE status IN ($3, $4) AND stuck_at IS NULL AND next_attempt_at <= now()
+			AND (lease_until IS NULL OR lease_until < now())
+		ORDER BY next_attempt_at LIMIT $5
+		FOR UPDATE SKIP LOCKED
+	) c
+	WHERE o.id = c.id
+	RETURNING o.id, o.number, o.user_id, o.status, o.accrual, o.created_at, o.attempts;`
+
+	if err := s.db.SelectContext(ctx, &orders, query,
+		owner, lease.Seconds(), StatusNew, StatusProccessing, limit); err != nil {
+		return nil, errors.Wrap(err, "claim orders")
+	}
+
+	return orders, nil
+}
+
+// RenewLease extends the lease held by owner by lease. It reports false when
+// owner does not hold the lease anymore.
+func (s *Storage) RenewLease(ctx context.Context, id int64, owner string, lease time.Duration) (bool, error) {
+	query := `UPDATE "order" SET lease_until = now() + $1::float8 * interval '1 second'
+	WHERE id = $2 AND lease_owner = $3;`
+
+	res, err := s.db.ExecContext(ctx, query, lease.Seconds(), id, owner)
+	if err != nil {
+		return false, errors.Wrap(err, "renew lease")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "renew lease")
+	}
+
+	return n == 1, nil
+}
+
+// ReleaseOrder drops the lease held by owner so the order can be claimed again.
+func (s *Storage) ReleaseOrder(ctx context.Context, id int64, owner string) error {
+	query := `UPDATE "order" SET lease_owner = NULL, lease_until = NULL
+	WHERE id = $1 AND lease_owner = $2;`
+
+	if _, err := s.db.ExecContext(ctx, query, id, owner); err != nil {
+		return errors.Wrap(err, "release order")
+	}
+
+	return nil
+}
+
+// RescheduleOrder counts a poll of the order, drops the lease held by owner and
+// postpones the next poll until next. Only polls count as attempts, not claims
+// released without polling. Orders uploaded more than maxAge ago are marked as stuck and are
+// not claimed anymore; a zero maxAge disables this. It reports whether the
+// order became stuck.
+func (s *Storage) RescheduleOrder(ctx context.Context, id int64, owner string, next time.Time, maxAge time.Duration) (bool, error) {
+	var stuck bool
+	query := `UPDATE "order" SET lease_owner = NULL, lease_until = NULL, next_attempt_at = $1,
+		attempts = attempts + 1,
+		stuck_at = CASE WHEN $2::float8 > 0 AND created_at < now() - $2::float8 * interval '1 second' THEN now() END
+	WHERE id = $3 AND lease_owner = $4 RETURNING stuck_at IS NOT NULL;`
+
+	if err := s.db.QueryRowContext(ctx, query, next, maxAge.Seconds(), id, owner).Scan(&stuck); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "reschedule order")
+	}
+
+	return stuck, nil
+}
+
+// UpdateOrderAccrual applies the accrual system result to the order and credits
+// the user balance when the order becomes PROCESSED. Orders that already reached
+// a final status are left untouched, so repeated results are no-ops. The row
+// lock taken by the UPDATE serializes concurrent appliers, so the accrual is
+// credited exactly once even if several replicas poll the same order.
+// The lease is kept for non-final statuses so the poller can reschedule them.
+func (s *Storage) UpdateOrderAccrual(ctx context.Context, info *model.AccrualInfo) error {
+	status, err := orderStatus(info.Status)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	var id, uid int64
+	query := `UPDATE "order" SET status = $1, accrual = $2, updated_at = now(),
+		lease_owner = CASE WHEN $1 IN ($4, $5) THEN NULL ELSE lease_owner END,
+		lease_until = CASE WHEN $1 IN ($4, $5) THEN NULL ELSE lease_until END
+	WHERE number = $3 AND status NOT IN ($4, $5) RETURNING id, user_id;`
+	err = tx.QueryRowContext(ctx, query, status, info.Accrual, info.Order, StatusInvalid, StatusProcessed).
+		Scan(&id, &uid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return errors.Wrap(err, "update order")
+	}
+
+	if status == StatusProcessed && info.Accrual != nil {
+		if err := s.creditAccrual(ctx, tx, uid, id, *info.Accrual); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit")
+	}
+
+	return nil
+}
+
+// orderStatus maps the accrual system status to the order status.
+// REGISTERED is not exposed to users and is reported as PROCESSING.
+func orderStatus(accrualStatus string) (string, error) {
+	switch accrualStatus {
+	case StatusRegistered, StatusProccessing:
+		return StatusProccessing, nil
+	case StatusInvalid, StatusProcessed:
+		return accrualStatus, nil
+	default:
+		return "", errors.Wrapf(storage.ErrUnknownStatus, "status %q", accrualStatus)
+	}
+}
@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/nbvehbq/go-loyalty-service/internal/logger"
+	"github.com/nbvehbq/go-loyalty-service/internal/model"
+	"github.com/nbvehbq/go-loyalty-service/internal/session"
+	"github.com/nbvehbq/go-loyalty-service/internal/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// SessionStorage keeps sessions in the database, so they survive restarts
+// and are shared by replicas. Only hashes of session IDs are stored.
+type SessionStorage struct {
+	storage *Storage
+	ttl     time.Duration
+}
+
+// NewSessionStorage expires sessions after ttl without use and deletes
+// expired ones every clearInterval.
+func NewSessionStorage(ctx context.Context, s *Storage, ttl, clearInterval time.Duration) *SessionStorage {
+	ss := &SessionStorage{storage: s, ttl: ttl}
+
+	go func() {
+		ticker := time.NewTicker(clearInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := ss.reduceSessions(ctx); err != nil {
+					logger.Log.Error("reduce sessions", zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	return ss
+}
+
+func (s *SessionStorage) Set(ctx context.Context, id int64, meta model.SessionMeta) (string, error) {
+	sid, err := session.NewID()
+	if err != nil {
+		return "", err
+	}
+
+	query := `INSERT INTO "session" (id_hash, user_id, user_agent, ip, expires_at)
+	VALUES ($1, $2, $3, $4, $5);`
+	if _, err := s.storage.db.ExecContext(ctx, query,
+		session.HashID(sid), id, meta.UserAgent, meta.IP, time.Now().Add(s.ttl)); err != nil {
+		return "", errors.Wrap(err, "create session")
+	}
+
+	return sid, nil
+}
+
+// Get returns the session user and extends the session by its TTL.
+func (s *SessionStorage) Get(ctx context.Context, sid string) (int64, bool) {
+	var id int64
+	query := `UPDATE "session" SET expires_at = $1, last_seen_at = now()
+	WHERE id_hash = $2 AND expires_at > now() RETURNING user_id;`
+
+	if err := s.storage.db.GetContext(ctx, &id, query, time.Now().Add(s.ttl), session.HashID(sid)); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Log.Error("get session", zap.Error(err))
+		}
+		return 0, false
+	}
+
+	return id, true
+}
+
+func (s *SessionStorage) Delete(ctx context.Context, sid string) error {
+	query := `DELETE FROM "session" WHERE id_hash = $1;`
+	if _, err := s.storage.db.ExecContext(ctx, query, session.HashID(sid)); err != nil {
+		return errors.Wrap(err, "delete session")
+	}
+
+	return nil
+}
+
+func (s *SessionStorage) DeleteAllForUser(ctx context.Context, id int64) error {
+	query := `DELETE FROM "session" WHERE user_id = $1;`
+	if _, err := s.storage.db.ExecContext(ctx, query, id); err != nil {
+		return errors.Wrap(err, "delete user sessions")
+	}
+
+	return nil
+}
+
+// List returns active sessions of the user, most recently used first.
+func (s *SessionStorage) List(ctx context.Context, id int64) ([]model.Session, error) {
+	res := make([]model.Session, 0)
+	query := `SELECT id_hash, user_agent, ip, created_at, last_seen_at FROM "session"
+	WHERE user_id = $1 AND expires_at > now() ORDER BY last_seen_at DESC;`
+
+	if err := s.storage.db.SelectContext(ctx, &res, query, id); err != nil {
+		return nil, errors.Wrap(err, "list sessions")
+	}
+
+	return res, nil
+}
+
+// DeleteByID ends the session of the user with the public ID returned by List.
+func (s *SessionStorage) DeleteByID(ctx context.Context, id int64, publicID string) error {
+	query := `DELETE FROM "session" WHERE user_id = $1 AND id_hash = $2;`
+	res, err := s.storage.db.ExecContext(ctx, query, id, publicID)
+	if err != nil {
+		return errors.Wrap(err, "delete session")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "delete session")
+	}
+	if n == 0 {
+		return storage.ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (s *SessionStorage) reduceSessions(ctx context.Context) error {
+	query := `DELETE FROM "session" WHERE expires_at <= now();`
+	if _, err := s.storage.db.ExecContext(ctx, query); err != nil {
+		return errors.Wrap(err, "delete expired sessions")
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nbvehbq/go-loyalty-service/internal/model"
+	"github.com/pkg/errors"
+)
+
+// GetTierStatus returns the user tier computed from the trailing accruals.
+func (s *Storage) GetTierStatus(ctx context.Context, uid int64) (*model.TierStatus, error) {
+	accrued, err := trailingAccrual(ctx, s.db, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return model.NewTierStatus(s.tiers, accrued), nil
+}
+
+// creditAccrual credits the order accrual and, when the user tier has a
+// multiplier, the extra points as a separate tier bonus entry. The tier is
+// the one reached before this accrual.
+func (s *Storage) creditAccrual(ctx context.Context, tx *sqlx.Tx, uid, orderID int64, accrual model.Money) error {
+	accrued, err := trailingAccrual(ctx, tx, uid)
+	if err != nil {
+		return err
+	}
+
+	if err := s.credit(ctx, tx, &model.LedgerEntry{
+		UserID:  uid,
+		Kind:    model.LedgerAccrual,
+		Amount:  accrual,
+		OrderID: &orderID,
+	}); err != nil {
+		return err
+	}
+
+	tier, _ := s.tiers.For(accrued)
+	if tier == nil {
+		return nil
+	}
+
+	bonus := accrual.Mul(tier.Multiplier) - accrual
+	if bonus <= 0 {
+		return nil
+	}
+
+	return s.credit(ctx, tx, &model.LedgerEntry{
+		UserID:  uid,
+		Kind:    model.LedgerTierBonus,
+		Amount:  bonus,
+		OrderID: &orderID,
+		Note:    fmt.Sprintf("%s x%g", tier.Name, tier.Multiplier),
+	})
+}
+
+// trailingAccrual sums the order accruals of the last twelve months,
+// tier bonuses excluded.
+func trailingAccrual(ctx context.Context, q sqlx.QueryerContext, uid int64) (model.Money, error) {
+	var accrued model.Money
+	query := `SELECT COALESCE(SUM(amount), 0) FROM "ledger_entry"
+	WHERE user_id = $1 AND kind = $2 AND created_at > now() - interval '12 months';`
+
+	if err := sqlx.GetContext(ctx, q, &accrued, query, uid, model.LedgerAccrual); err != nil {
+		return 0, errors.Wrap(err, "sum trailing accruals")
+	}
+
+	return accrued, nil
+}
@@ -3,8 +3,19 @@ package storage
 import "errors"
 
 var (
-	ErrUserExists          = errors.New("user exists")
-	ErrUserNotFound        = errors.New("user not found")
-	ErrOrderNotFound       = errors.New("order not found")
-	ErrBalanceInsufficient = errors.New("balance insufficient")
+	ErrUserExists               = errors.New("user exists")
+	ErrUserNotFound             = errors.New("user not found")
+	ErrOrderNotFound            = errors.New("order not found")
+	ErrOrderAlreadyUploaded     = errors.New("order already uploaded")
+	ErrOrderOwnedByOther        = errors.New("order uploaded by another user")
+	ErrBalanceInsufficient      = errors.New("balance insufficient")
+	ErrUnknownStatus            = errors.New("unknown accrual status")
+	ErrWithdrawalExists         = errors.New("withdrawal for the order exists")
+	ErrWithdrawalNotFound       = errors.New("withdrawal not found")
+	ErrWithdrawalReversed       = errors.New("withdrawal already reversed")
+	ErrTransferToSelf           = errors.New("transfer to self")
+	ErrIdempotencyKeyMismatch   = errors.New("idempotency key reused with another request")
+	ErrIdempotencyKeyInProgress = errors.New("request with the idempotency key is in progress")
+	ErrSessionNotFound          = errors.New("session not found")
+	ErrSessionUnsupported       = errors.New("not supported by the session store")
 )
@@ -0,0 +1,31 @@
+package worker
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes the delay before the next poll of an order.
+// The delay doubles with every attempt up to Max and is spread by
+// +/- Jitter fraction so that orders uploaded together do not poll together.
+type Backoff struct {
+	Base   time.Duration
+	Max    time.Duration
+	Jitter float64
+}
+
+func (b Backoff) Next(attempt int) time.Duration {
+	d := b.Base
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+
+	if b.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * b.Jitter * float64(d))
+	}
+
+	return d
+}
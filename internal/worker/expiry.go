@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/nbvehbq/go-loyalty-service/internal/logger"
+	"go.uber.org/zap"
+)
+
+const expiryBatchSize = 1000
+
+type ExpiryRepository interface {
+	ExpirePoints(ctx context.Context, limit int) (int, error)
+}
+
+// Expirer periodically writes off points whose lots expired.
+type Expirer struct {
+	storage  ExpiryRepository
+	interval time.Duration
+}
+
+func NewExpirer(storage ExpiryRepository, interval time.Duration) *Expirer {
+	return &Expirer{
+		storage:  storage,
+		interval: interval,
+	}
+}
+
+// Run expires points until ctx is done.
+func (e *Expirer) Run(ctx context.Context) {
+	logger.Log.Info("Points expirer started.")
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Points expirer stopped.")
+			return
+		case <-ticker.C:
+			e.expire(ctx)
+		}
+	}
+}
+
+func (e *Expirer) expire(ctx context.Context) {
+	for {
+		n, err := e.storage.ExpirePoints(ctx, expiryBatchSize)
+		if err != nil {
+			logger.Log.Error("expire points", zap.Error(err))
+			return
+		}
+		if n > 0 {
+			logger.Log.Info("points lots expired", zap.Int("count", n))
+		}
+		if n < expiryBatchSize {
+			return
+		}
+	}
+}
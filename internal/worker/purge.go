@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/nbvehbq/go-loyalty-service/internal/logger"
+	"go.uber.org/zap"
+)
+
+type PurgeRepository interface {
+	PurgeIdempotencyKeys(ctx context.Context, ttl time.Duration) (int64, error)
+}
+
+// Purger periodically deletes idempotency keys older than their TTL.
+type Purger struct {
+	storage  PurgeRepository
+	ttl      time.Duration
+	interval time.Duration
+}
+
+func NewPurger(storage PurgeRepository, ttl, interval time.Duration) *Purger {
+	return &Purger{
+		storage:  storage,
+		ttl:      ttl,
+		interval: interval,
+	}
+}
+
+// Run purges idempotency keys until ctx is done.
+func (p *Purger) Run(ctx context.Context) {
+	logger.Log.Info("Idempotency key purger started.")
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Idempotency key purger stopped.")
+			return
+		case <-ticker.C:
+			n, err := p.storage.PurgeIdempotencyKeys(ctx, p.ttl)
+			if err != nil {
+				logger.Log.Error("purge idempotency keys", zap.Error(err))
+				continue
+			}
+			if n > 0 {
+				logger.Log.Info("idempotency keys purged", zap.Int64("count", n))
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nbvehbq/go-loyalty-service/internal/accrual"
+	"github.com/nbvehbq/go-loyalty-service/internal/logger"
+	"github.com/nbvehbq/go-loyalty-service/internal/model"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+type Repository interface {
+	ClaimOrders(ctx context.Context, owner string, lease time.Duration, limit int) ([]model.Order, error)
+	RenewLease(ctx context.Context, id int64, owner string, lease time.Duration) (bool, error)
+	ReleaseOrder(ctx context.Context, id int64, owner string) error
+	RescheduleOrder(ctx context.Context, id int64, owner string, next time.Time, maxAge time.Duration) (bool, error)
+	UpdateOrderAccrual(ctx context.Context, info *model.AccrualInfo) error
+	RecordOrderPoll(ctx context.Context, poll *model.OrderPoll) error
+}
+
+type AccrualClient interface {
+	GetOrder(ctx context.Context, number string) (*model.AccrualInfo, error)
+	Available() bool
+}
+
+type Config struct {
+	Owner     string
+	Interval  time.Duration
+	LeaseTTL  time.Duration
+	Workers   int
+	BatchSize int
+	Backoff   Backoff
+	MaxAge    time.Duration
+}
+
+type Worker struct {
+	storage Repository
+	client  AccrualClient
+	cfg     Config
+}
+
+func NewWorker(storage Repository, client AccrualClient, cfg Config) *Worker {
+	return &Worker{
+		storage: storage,
+		client:  client,
+		cfg:     cfg,
+	}
+}
+
+// Run polls the accrual system for unprocessed orders until ctx is done.
+func (w *Worker) Run(ctx context.Context) {
+	logger.Log.Info("Accrual worker started.")
+
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Accrual worker stopped.")
+			return
+		case <-ticker.C:
+			w.poll(ctx)
+		}
+	}
+}
+
+func (w *Worker) poll(ctx context.Context) {
+	if !w.client.Available() {
+		logger.Log.Debug("accrual system unavailable, polling paused")
+		return
+	}
+
+	orders, err := w.storage.ClaimOrders(ctx, w.cfg.Owner, w.cfg.LeaseTTL, w.cfg.BatchSize)
+	if err != nil {
+		logger.Log.Error("claim orders", zap.Error(err))
+		return
+	}
+
+	jobs := make(chan model.Order)
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for order := range jobs {
+				w.process(ctx, order)
+			}
+		}()
+	}
+
+loop:
+	for _, order := range orders {
+		select {
+		case <-ctx.Done():
+			break loop
+		case jobs <- order:
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+}
+
+// process polls the accrual system for the order. The lease is renewed while
+// the request waits for the rate limiter, so the order is not claimed by
+// another replica meanwhile; if the lease is lost anyway, processing stops.
+func (w *Worker) process(ctx context.Context, order model.Order) {
+	held, err := w.storage.RenewLease(ctx, order.ID, w.cfg.Owner, w.cfg.LeaseTTL)
+	if err != nil {
+		logger.Log.Error("renew lease", zap.String("order", order.Number), zap.Error(err))
+		return
+	}
+	if !held {
+		logger.Log.Warn("order lease lost", zap.String("order", order.Number))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go w.keepLease(ctx, cancel, order)
+
+	info, err := w.client.GetOrder(ctx, order.Number)
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, accrual.ErrTooManyRequests), errors.Is(err, accrual.ErrCircuitOpen):
+			logger.Log.Debug("accrual unavailable", zap.String("order", order.Number), zap.Error(err))
+			w.release(ctx, order)
+			return
+		case errors.Is(err, accrual.ErrOrderNotRegistered):
+		default:
+			logger.Log.Error("get accrual", zap.String("order", order.Number), zap.Error(err))
+		}
+		w.record(ctx, &model.OrderPoll{OrderNumber: order.Number, Error: err.Error()})
+		w.reschedule(ctx, order)
+		return
+	}
+
+	w.record(ctx, &model.OrderPoll{OrderNumber: order.Number, Status: info.Status, Accrual: info.Accrual})
+
+	if err := w.storage.UpdateOrderAccrual(ctx, info); err != nil {
+		logger.Log.Error("update order accrual", zap.String("order", order.Number), zap.Error(err))
+		w.reschedule(ctx, order)
+		return
+	}
+
+	if !isFinal(info.Status) {
+		w.reschedule(ctx, order)
+	}
+}
+
+// keepLease renews the order lease until ctx is done and calls cancel when
+// the lease is lost.
+func (w *Worker) keepLease(ctx context.Context, cancel context.CancelFunc, order model.Order) {
+	ticker := time.NewTicker(w.cfg.LeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			held, err := w.storage.RenewLease(ctx, order.ID, w.cfg.Owner, w.cfg.LeaseTTL)
+			if err != nil {
+				logger.Log.Error("renew lease", zap.String("order", order.Number), zap.Error(err))
+				continue
+			}
+			if !held {
+				logger.Log.Warn("order lease lost", zap.String("order", order.Number))
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// reschedule counts the poll just made, on top of the previous attempts of
+// the order, and delays the next one accordingly.
+func (w *Worker) reschedule(ctx context.Context, order model.Order) {
+	attempts := order.Attempts + 1
+	next := time.Now().Add(w.cfg.Backoff.Next(attempts))
+
+	stuck, err := w.storage.RescheduleOrder(ctx, order.ID, w.cfg.Owner, next, w.cfg.MaxAge)
+	if err != nil {
+		logger.Log.Error("reschedule order", zap.String("order", order.Number), zap.Error(err))
+		return
+	}
+
+	if stuck {
+		logger.Log.Warn("order is stuck, polling stopped",
+			zap.String("order", order.Number), zap.Int("attempts", attempts))
+	}
+}
+
+func (w *Worker) record(ctx context.Context, poll *model.OrderPoll) {
+	poll.Source = model.PollSourcePoller
+	if err := w.storage.RecordOrderPoll(ctx, poll); err != nil {
+		logger.Log.Error("record order poll", zap.String("order", poll.OrderNumber), zap.Error(err))
+	}
+}
+
+func (w *Worker) release(ctx context.Context, order model.Order) {
+	if err := w.storage.ReleaseOrder(ctx, order.ID, w.cfg.Owner); err != nil {
+		logger.Log.Error("release order", zap.String("order", order.Number), zap.Error(err))
+	}
+}
+
+func isFinal(status string) bool {
+	return status == accrual.StatusInvalid || status == accrual.StatusProcessed
+}